@@ -1,3 +1,5 @@
 module github.com/morganxf/example
 
-go 1.14
+go 1.16
+
+require github.com/rogpeppe/go-internal v1.6.1
@@ -0,0 +1,24 @@
+package filepath
+
+import "os"
+
+// IsAbs 判断path是否为绝对路径。只考虑linux，以分隔符开头即为绝对路径
+func IsAbs(path string) bool {
+	return len(path) > 0 && os.IsPathSeparator(path[0])
+}
+
+// Abs 返回path的绝对路径
+//
+// 如果path不是绝对路径，则以当前工作目录为基准join。结果都会经过Clean处理
+// 由于工作目录可能发生变化，同一个相对路径在不同时刻调用Abs的结果可能不同
+func Abs(path string) (string, error) {
+	if IsAbs(path) {
+		return Clean(path), nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	// Join内部会调用Clean
+	return Join(wd, path), nil
+}
@@ -0,0 +1,19 @@
+package filepath
+
+import (
+	"context"
+	"io/fs"
+)
+
+var LstatP = &lstat
+
+// WalkVisitorFS is WalkVisitor over fsys, so tests can inject read errors.
+func WalkVisitorFS(fsys fs.FS, root string, opts WalkOptions, v Visitor) error {
+	return newVisitorWalker(ioFS{fsys: fsys}, opts, v).walkRoot(root)
+}
+
+// GlobWithOptionsFS is GlobWithOptions over fsys, so tests can inject read errors.
+func GlobWithOptionsFS(fsys fs.FS, pattern string, opts GlobOptions) ([]string, error) {
+	g := &globber{ctx: context.Background(), fsys: ioFS{fsys: fsys}, opts: opts}
+	return g.run(pattern)
+}
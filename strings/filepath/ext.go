@@ -0,0 +1,14 @@
+package filepath
+
+import "os"
+
+// Ext 返回path中文件名的扩展名，即最后一个'.'及其之后的部分。如果没有'.'，返回""
+// 从右向左迭代，遇到分隔符即停止，所以目录名中的'.'不会被当作扩展名。Ext("a.dir/b") == ""
+func Ext(path string) string {
+	for i := len(path) - 1; i >= 0 && !os.IsPathSeparator(path[i]); i-- {
+		if path[i] == '.' {
+			return path[i:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package filepath
+
+import (
+	"strings"
+)
+
+// pathFilter WalkOptions.Include、Exclude中的一个pattern
+type pathFilter struct {
+	pattern *Pattern
+	// pattern中包含Separator时匹配相对于root的路径，否则只匹配文件名
+	matchPath bool
+}
+
+// compileFilters 解析patterns，任意一个存在语法错误时返回ErrBadPattern
+func compileFilters(patterns []string) ([]pathFilter, error) {
+	filters := make([]pathFilter, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, pathFilter{
+			pattern:   p,
+			matchPath: strings.Contains(pattern, string(Separator)),
+		})
+	}
+	return filters, nil
+}
+
+// matchFilters name是否匹配filters中的任意一个，rel为name相对于root的路径
+func matchFilters(filters []pathFilter, rel, name string) bool {
+	for _, f := range filters {
+		target := name
+		if f.matchPath {
+			target = rel
+		}
+		if f.pattern.Match(target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package filepath
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// fileSystem Walk和Glob所依赖的文件系统操作
+//
+// osFS对应真实的操作系统文件系统，ioFS对应fs.FS
+type fileSystem interface {
+	Lstat(name string) (os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir 返回按名称排序的目录项，出错时返回已经读取的部分
+	ReadDir(dirname string) ([]fs.DirEntry, error)
+	// EntryInfo 返回目录dirname中entry的os.FileInfo，与Lstat相同，不跟随符号链接
+	EntryInfo(dirname string, entry fs.DirEntry) (os.FileInfo, error)
+	// OpenDir 打开目录，用于分批读取目录项
+	OpenDir(dirname string) (dirReader, error)
+	ReadFile(name string) ([]byte, error)
+}
+
+// dirReader 分批读取目录项，*os.File以及fs.ReadDirFile满足该接口
+//
+// ReadDir(n)的语义与*os.File相同：n > 0时最多返回n个目录项，不排序，读取完毕时返回io.EOF
+type dirReader interface {
+	ReadDir(n int) ([]fs.DirEntry, error)
+	Close() error
+}
+
+// osFS 操作系统文件系统
+type osFS struct{}
+
+// Lstat 使用lstat变量而不是直接调用os.Lstat，保证单元测试可以替换
+func (osFS) Lstat(name string) (os.FileInfo, error)        { return lstat(name) }
+func (osFS) Stat(name string) (os.FileInfo, error)         { return os.Stat(name) }
+func (osFS) ReadDir(dirname string) ([]fs.DirEntry, error) { return os.ReadDir(dirname) }
+
+// EntryInfo 同样使用lstat变量，而不是entry.Info()
+func (osFS) EntryInfo(dirname string, entry fs.DirEntry) (os.FileInfo, error) {
+	return lstat(Join(dirname, entry.Name()))
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) { return os.ReadFile(name) }
+
+// Readlink osFS同时实现了SymlinkFS
+func (osFS) Readlink(name string) (string, error) { return os.Readlink(name) }
+
+func (osFS) OpenDir(dirname string) (dirReader, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ioFS 将fs.FS适配为fileSystem
+//
+// fs.FS要求使用'/'作为分隔符，由于只考虑linux，Separator就是'/'，Match、Split、Join可以直接复用
+type ioFS struct {
+	fsys fs.FS
+}
+
+// Lstat io/fs中没有Lstat，只能使用fs.Stat，会跟随符号链接。
+// 因此只用于root等无法从目录项得到os.FileInfo的路径，目录中的路径使用EntryInfo
+func (f ioFS) Lstat(name string) (os.FileInfo, error) { return fs.Stat(f.fsys, name) }
+func (f ioFS) Stat(name string) (os.FileInfo, error)  { return fs.Stat(f.fsys, name) }
+
+// ReadDir fs.ReadDir返回的entries已经排序
+func (f ioFS) ReadDir(dirname string) ([]fs.DirEntry, error) { return fs.ReadDir(f.fsys, dirname) }
+
+// EntryInfo fs.DirEntry.Info()不跟随符号链接，比如os.DirFS返回的就是lstat的结果
+func (f ioFS) EntryInfo(dirname string, entry fs.DirEntry) (os.FileInfo, error) {
+	return entry.Info()
+}
+
+func (f ioFS) ReadFile(name string) ([]byte, error) { return fs.ReadFile(f.fsys, name) }
+
+// OpenDir fs.FS中的目录需要实现fs.ReadDirFile才能分批读取
+func (f ioFS) OpenDir(dirname string) (dirReader, error) {
+	file, err := f.fsys.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := file.(fs.ReadDirFile)
+	if !ok {
+		file.Close()
+		return nil, &fs.PathError{Op: "readdir", Path: dirname, Err: errors.New("not implemented")}
+	}
+	return d, nil
+}
@@ -1,95 +1,375 @@
 package filepath
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
 	"os"
-	"sort"
 	"strings"
+	"syscall"
 )
 
 // Glob 根据输入的pattern匹配所有的文件。如果没有匹配到，返回nil
 //
-// Glob 递归match
-// 1. 假定第n级dirs已经匹配，那么就需要遍历dirs调用glob匹配file，得到第n+1 dirs
-// 2. 递归终止条件，dir已经匹配展开
+// Glob 逐层match
+// 1. 以SplitPattern得到的最长字面量目录为起点，不读取其中的各级目录
+// 2. 假定第n级dirs已经匹配，那么就需要遍历dirs匹配第n+1级的subPattern，得到第n+1 dirs
+// 3. 终止条件，pattern的最后一级已经匹配
+//
+// pattern中包含"**" subPath时，由globDoubleStar使用Walk展开
+// pattern中包含"{a,b}"时，先展开为多个pattern分别Glob，结果按展开顺序合并去重
+//
+// Glob忽略所有的I/O错误，比如没有权限读取的目录，唯一可能返回的错误是ErrBadPattern。
+// 如果需要知道这些错误，使用GlobWithOptions
 func Glob(pattern string) (matches []string, err error) {
-	if !hasMeta(pattern) {
-		if _, err = os.Lstat(pattern); err != nil {
-			return nil, nil
+	return GlobWithOptions(pattern, GlobOptions{})
+}
+
+// GlobErrorPolicy Glob遇到I/O错误时的处理方式
+//
+// 文件不存在(os.ErrNotExist)以及路径中间的部分不是目录(syscall.ENOTDIR)只是说明没有匹配，不作为I/O错误
+type GlobErrorPolicy int
+
+const (
+	// GlobIgnoreErrors 跳过无法读取的目录，忽略I/O错误。Glob的默认行为
+	GlobIgnoreErrors GlobErrorPolicy = iota
+	// GlobFailFast 遇到第一个I/O错误时立即返回该*os.PathError，不返回任何匹配
+	GlobFailFast
+	// GlobCollectErrors 跳过无法读取的目录继续匹配，最终返回所有的匹配以及GlobErrors
+	GlobCollectErrors
+)
+
+// GlobOptions GlobWithOptions的选项，零值与Glob的行为相同
+type GlobOptions struct {
+	ErrorPolicy GlobErrorPolicy
+
+	// Unsorted 与WalkOptions.Unsorted相同，每次读取BatchSize个目录项并立即匹配，不再读取整个目录并排序。
+	// 只有匹配的路径会被保留，但是返回的matches不再按目录内的字典序排列
+	Unsorted bool
+	// BatchSize Unsorted时每次读取的目录项数量，<=0时使用defaultBatchSize
+	BatchSize int
+}
+
+// GlobErrors GlobCollectErrors模式下收集到的所有I/O错误，按照发生的顺序排列
+type GlobErrors []*os.PathError
+
+func (e GlobErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// GlobWithOptions 与Glob相同，但是可以通过opts.ErrorPolicy指定I/O错误的处理方式
+func GlobWithOptions(pattern string, opts GlobOptions) (matches []string, err error) {
+	g := &globber{ctx: context.Background(), fsys: osFS{}, opts: opts}
+	return g.run(pattern)
+}
+
+// GlobFunc 与GlobWithOptions相同，但是不返回所有的匹配，而是每匹配到一个路径就调用fn
+//
+// fn返回SkipAll时立即停止匹配，GlobFunc返回nil；返回其他错误时立即停止匹配，GlobFunc返回该错误。
+// SkipDir没有特殊含义，与其他错误相同，不会只跳过匹配所在的目录。
+// 与opts.Unsorted一起使用，且pattern中不包含'{}'时，无论目录和匹配有多少，占用的内存都是有上限的。
+// pattern中包含'{}'时，展开的多个pattern可能匹配同一个路径，需要记录所有的匹配用于去重。
+// GlobCollectErrors时，收集到的GlobErrors在所有的匹配完成之后返回
+func GlobFunc(pattern string, opts GlobOptions, fn func(match string) error) error {
+	g := &globber{ctx: context.Background(), fsys: osFS{}, opts: opts}
+	if err := g.each(pattern, fn); err != nil {
+		if err == SkipAll {
+			return nil
 		}
-		return []string{pattern}, nil
+		return err
 	}
+	if len(g.errs) > 0 {
+		return g.errs
+	}
+	return nil
+}
 
-	// path中包含magic chars
-	dir, file := Split(pattern)
-	dir = cleanGlobPath(dir)
+// GlobContext 与Glob相同，但是可以通过ctx取消匹配
+//
+// 每次读取目录之前检查ctx，ctx被取消时停止匹配并返回nil, ctx.Err()
+func GlobContext(ctx context.Context, pattern string) (matches []string, err error) {
+	g := &globber{ctx: ctx, fsys: osFS{}}
+	return g.run(pattern)
+}
+
+// GlobFS 与Glob相同，但是匹配的是fsys中的文件
+//
+// 遵循io/fs的约定，pattern以'/'分隔，且不以'/'开头
+func GlobFS(fsys fs.FS, pattern string) (matches []string, err error) {
+	g := &globber{ctx: context.Background(), fsys: ioFS{fsys: fsys}}
+	return g.run(pattern)
+}
 
-	// 递归终止条件
-	// dir不包含魔法字符，处于已展开匹配状态
-	if !hasMeta(dir) {
-		return glob(dir, file, nil)
+// globber 保存一次Glob调用的ctx、文件系统、选项以及收集到的错误
+type globber struct {
+	ctx  context.Context
+	fsys fileSystem
+	opts GlobOptions
+	errs GlobErrors
+}
+
+// run 收集each得到的所有匹配
+func (g *globber) run(pattern string) (matches []string, err error) {
+	err = g.each(pattern, func(match string) error {
+		matches = append(matches, match)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	if len(g.errs) > 0 {
+		return matches, g.errs
+	}
+	return matches, nil
+}
 
-	var m []string
-	// 递归调用Glob
-	// 由于dir包含魔法字符，需要递归处理dir，知道不包含魔法字符
-	m, err = Glob(dir)
+// each 展开pattern中的'{}'，分别glob并按展开顺序对每一个不重复的匹配调用fn
+func (g *globber) each(pattern string, fn func(match string) error) error {
+	patterns, err := expandBraces(pattern)
 	if err != nil {
-		return
+		return err
+	}
+	if len(patterns) == 1 {
+		return g.glob(patterns[0], fn)
 	}
-	// 递归后处理
-	for _, d := range m {
-		// 循环更新matches
-		matches, err = glob(d, file, matches)
+	seen := make(map[string]bool)
+	for _, p := range patterns {
+		err := g.glob(p, func(match string) error {
+			if seen[match] {
+				return nil
+			}
+			seen[match] = true
+			return fn(match)
+		})
 		if err != nil {
-			return
+			return err
 		}
 	}
-	return
+	return nil
 }
 
-func cleanGlobPath(path string) string {
-	switch path {
-	case "":
-		return "."
-	case string(os.PathSeparator):
-		return path
-	default:
-		// 去掉末尾的'/'。由Split知道，返回的dir末尾包含'/'
-		return path[:len(path)-1]
+// report 根据ErrorPolicy处理I/O错误。返回非nil时，Glob立即终止并返回该错误
+func (g *globber) report(path string, err error) error {
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return nil
+	}
+	pathErr, ok := err.(*os.PathError)
+	if !ok {
+		pathErr = &os.PathError{Op: "glob", Path: path, Err: err}
+	}
+	switch g.opts.ErrorPolicy {
+	case GlobFailFast:
+		return pathErr
+	case GlobCollectErrors:
+		g.errs = append(g.errs, pathErr)
 	}
+	return nil
 }
 
-// glob dir已经匹配展开的情况下，寻找dir下匹配pattern的文件，并join增加到matches列表中.
-// 如果存在问题，matches不变、返回。
-func glob(dir, pattern string, matches []string) ([]string, error) {
-	fi, err := os.Stat(dir)
+// glob 匹配已经展开'{}'的pattern，对每一个匹配调用fn。fn返回的错误会终止匹配并原样返回
+func (g *globber) glob(pattern string, fn func(match string) error) error {
+	if hasDoubleStar(pattern) {
+		return g.globDoubleStar(pattern, fn)
+	}
+	if name, ok := unescapeLiteral(pattern); ok {
+		if _, err := g.fsys.Lstat(name); err != nil {
+			return g.report(name, err)
+		}
+		return fn(name)
+	}
+
+	// 从最长的字面量目录开始匹配，"var/log/app-*/2024/*.gz"只需要从"var/log"开始读取
+	dir, rest := SplitPattern(pattern)
+	if dir == "" {
+		dir = "."
+	}
+	return g.globSegments(dir, rest, fn)
+}
+
+// globSegments dir已经匹配展开的情况下，逐层匹配rest中的各个subPattern
+//
+// 每展开一个目录，立即匹配下一层的subPattern，最后一层的匹配交给fn
+func (g *globber) globSegments(dir, rest string, fn func(match string) error) error {
+	pattern, next := rest, fn
+	if i := strings.IndexByte(rest, Separator); i >= 0 {
+		pattern, rest = rest[:i], rest[i+1:]
+		next = func(d string) error {
+			return g.globSegments(d, rest, fn)
+		}
+	}
+	return g.globDir(dir, pattern, next)
+}
+
+// globDir dir已经匹配展开的情况下，寻找dir下匹配pattern的文件，join之后调用fn.
+// 如果存在I/O问题，交由report处理
+func (g *globber) globDir(dir, pattern string, fn func(match string) error) error {
+	fi, err := g.fsys.Stat(dir)
 	if err != nil {
-		// matches不变、返回
-		return matches, nil
+		return g.report(dir, err)
 	}
 	if !fi.IsDir() {
-		return matches, nil
+		return nil
+	}
+	if err := g.ctx.Err(); err != nil {
+		return err
+	}
+	if g.opts.Unsorted {
+		return g.globDirUnsorted(dir, pattern, fn)
+	}
+	// 出错时entries中仍可能包含已经读取的部分
+	entries, err := g.fsys.ReadDir(dir)
+	if err != nil {
+		if err := g.report(dir, err); err != nil {
+			return err
+		}
 	}
-	d, err := os.Open(dir)
+	return matchEntries(dir, pattern, entries, fn)
+}
+
+// globDirUnsorted Unsorted时的globDir，每次读取BatchSize个目录项并匹配
+func (g *globber) globDirUnsorted(dir, pattern string, fn func(match string) error) error {
+	d, err := g.fsys.OpenDir(dir)
 	if err != nil {
-		return matches, nil
+		return g.report(dir, err)
 	}
 	defer d.Close()
-	names, _ := d.Readdirnames(-1)
-	sort.Strings(names)
-	for _, n := range names {
+	for {
+		entries, readErr := d.ReadDir(batchSize(g.opts.BatchSize))
+		if err := matchEntries(dir, pattern, entries, fn); err != nil {
+			return err
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return g.report(dir, readErr)
+		}
+	}
+}
+
+// matchEntries 对dir下匹配pattern的entries，join之后调用fn
+func matchEntries(dir, pattern string, entries []fs.DirEntry, fn func(match string) error) error {
+	for _, entry := range entries {
+		n := entry.Name()
 		matched, err := Match(pattern, n)
 		if err != nil {
-			return matches, err
+			return err
 		}
 		if matched {
-			matches = append(matches, Join(dir, n))
+			if err := fn(Join(dir, n)); err != nil {
+				return err
+			}
 		}
 	}
-	return matches, nil
+	return nil
 }
 
-func hasMeta(path string) bool {
-	magicChars := "*?["
-	return strings.ContainsAny(path, magicChars)
+// globDoubleStar 匹配包含"**" subPath的pattern
+//
+// 1. 以第一个"**"为界，将pattern分为dir和rest两部分。"src/*/**/*.go" -> dir="src/*", rest="**/*.go"
+// 2. 调用glob展开dir，dir中不包含"**"
+// 3. 使用Walk遍历展开后的各个目录，以完整的pattern逐个Match遍历到的path
+func (g *globber) globDoubleStar(pattern string, fn func(match string) error) error {
+	segments := splitSegments(pattern)
+	i := 0
+	for segments[i] != doubleStar {
+		i++
+	}
+	dir := strings.Join(segments[:i], string(Separator))
+	rest := strings.Join(segments[i:], string(Separator))
+
+	walkDir := func(d string) error {
+		fi, err := g.fsys.Stat(d)
+		if err != nil {
+			return g.report(d, err)
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		// d是已经展开的路径，其中可能包含魔法字符，需要转义之后再与rest拼接成完整的pattern
+		var p string
+		switch d {
+		case ".":
+			p = rest
+		case string(Separator):
+			p = d + rest
+		default:
+			p = quoteMeta(d) + string(Separator) + rest
+		}
+		// fn返回的错误单独记录，以SkipAll停止遍历。
+		// 不能直接返回给walker：SkipDir会被当作遍历的SkipDir跳过目录，SkipAll会被walkRoot吞掉
+		var fnErr error
+		// 已经匹配过、尚未离开的目录。Unsorted时目录读取到一半失败，walkFn会以该错误对目录第二次调用
+		var entered []string
+		w := &walker{ctx: g.ctx, fsys: g.fsys, opts: WalkOptions{Unsorted: g.opts.Unsorted, BatchSize: g.opts.BatchSize}}
+		w.walkFn = func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if err := g.report(path, err); err != nil {
+					return err
+				}
+				// lstat失败，无法访问的文件
+				if info == nil {
+					return nil
+				}
+				// 读取到一半失败的目录已经匹配过
+				if n := len(entered); n > 0 && entered[n-1] == path {
+					return nil
+				}
+				// ReadDir失败的目录本身仍然需要匹配
+			} else if info.IsDir() {
+				entered = append(entered, path)
+			}
+			if path == "." {
+				return nil
+			}
+			matched, err := Match(p, path)
+			if err != nil {
+				return err
+			}
+			if matched {
+				if fnErr = fn(path); fnErr != nil {
+					return SkipAll
+				}
+			}
+			return nil
+		}
+		w.leaveFn = func(path string, info os.FileInfo) error {
+			if n := len(entered); n > 0 && entered[n-1] == path {
+				entered = entered[:n-1]
+			}
+			return nil
+		}
+		if err := w.walkRoot(d); err != nil {
+			return err
+		}
+		return fnErr
+	}
+
+	switch {
+	// pattern以"**"开头，从当前目录开始遍历
+	case i == 0:
+		return walkDir(".")
+	// pattern为"/**..."，从根目录开始遍历
+	case dir == "":
+		return walkDir(string(Separator))
+	default:
+		return g.glob(dir, walkDir)
+	}
+}
+
+// quoteMeta 转义path中的魔法字符，使其可以作为pattern原样匹配path
+func quoteMeta(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if strings.IndexByte(`*?[{}\`, path[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
 }
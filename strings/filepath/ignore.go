@@ -0,0 +1,174 @@
+package filepath
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// IgnoreMatcher 按照gitignore的规则判断路径是否被忽略
+//
+// 在Match的基础上，gitignore的规则有以下不同：
+// 1. 以'#'开头的行是注释，空行以及末尾未转义的空格被忽略
+// 2. 以'!'开头的规则取反，重新包含之前被忽略的路径。但是父目录被忽略时，其中的路径无法被重新包含
+// 3. 以'/'结尾的规则只匹配目录
+// 4. 开头或者中间包含'/'的规则匹配相对于规则所在目录的路径，否则匹配任意深度的文件名
+// 5. "**"匹配任意层目录，"a/**"匹配a中所有的路径，但是不匹配a本身
+// 6. "[!a]"与"[^a]"相同，'{'、'}'没有特殊含义
+//
+// 多个规则匹配同一个路径时，最后添加的规则生效。规则按照目录由浅到深添加，深层目录中的规则优先。
+// 所有的路径都以'/'分隔，且相对于IgnoreMatcher的根目录
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+// ignoreRule gitignore文件中的一条规则
+type ignoreRule struct {
+	// 规则所在的目录，相对于IgnoreMatcher的根目录，根目录为""
+	dir     string
+	pattern *Pattern
+	negate  bool
+	dirOnly bool
+	// 规则中包含'/'时匹配相对于dir的路径，否则匹配文件名
+	anchored bool
+}
+
+// NewIgnoreMatcher 返回不包含任何规则的IgnoreMatcher
+func NewIgnoreMatcher() *IgnoreMatcher {
+	return &IgnoreMatcher{}
+}
+
+// AddPatterns 添加目录dir中的gitignore规则，每个line对应gitignore文件中的一行
+//
+// 与git相同，存在语法错误的规则被忽略
+func (m *IgnoreMatcher) AddPatterns(dir string, lines ...string) {
+	dir = Clean(dir)
+	if dir == "." {
+		dir = ""
+	}
+	for _, line := range lines {
+		if rule, ok := parseIgnoreRule(dir, line); ok {
+			m.rules = append(m.rules, rule)
+		}
+	}
+}
+
+// Add 从r中读取目录dir中的gitignore文件，并添加其中的规则
+func (m *IgnoreMatcher) Add(dir string, r io.Reader) error {
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	m.AddPatterns(dir, lines...)
+	return nil
+}
+
+// Ignored path是否被忽略，isDir表示path是否为目录
+//
+// path的任意一个父目录被忽略时，path也被忽略
+func (m *IgnoreMatcher) Ignored(path string, isDir bool) bool {
+	path = Clean(path)
+	if path == "." {
+		return false
+	}
+	for i := 1; i < len(path); i++ {
+		if path[i] == Separator && m.match(path[:i], true) {
+			return true
+		}
+	}
+	return m.match(path, isDir)
+}
+
+// match 不考虑父目录，path本身是否被忽略
+func (m *IgnoreMatcher) match(path string, isDir bool) bool {
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		r := &m.rules[i]
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel := path
+		if r.dir != "" {
+			if !strings.HasPrefix(path, r.dir+string(Separator)) {
+				continue
+			}
+			rel = path[len(r.dir)+1:]
+		}
+		if !r.anchored {
+			rel = Base(rel)
+		}
+		if r.pattern.Match(rel) {
+			return !r.negate
+		}
+	}
+	return false
+}
+
+// parseIgnoreRule 解析gitignore文件中的一行，注释、空行以及存在语法错误的规则返回false
+func parseIgnoreRule(dir, line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// 去除末尾未转义的空格
+	for len(line) > 0 && line[len(line)-1] == ' ' {
+		if len(line) >= 2 && line[len(line)-2] == '\\' {
+			break
+		}
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{dir: dir}
+	if line[0] == '!' {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = line[:len(line)-1]
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	// "a/**"不匹配a本身，而"**"在Match中可以匹配0层目录
+	if strings.HasSuffix(line, "/**") {
+		line += "/*"
+	}
+
+	p, err := Compile(ignoreToPattern(line))
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.pattern = p
+	return rule, true
+}
+
+// ignoreToPattern 将gitignore的规则转换为Match的pattern：转义'{'、'}'，"[!"转换为"[^"
+func ignoreToPattern(line string) string {
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			b.WriteByte(c)
+			i++
+			b.WriteByte(line[i])
+		case c == '{' || c == '}':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '[' && i+1 < len(line) && line[i+1] == '!':
+			b.WriteString("[^")
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
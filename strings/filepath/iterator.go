@@ -0,0 +1,135 @@
+package filepath
+
+import (
+	"io/fs"
+	"os"
+)
+
+// Walker 以迭代器的方式遍历root文件树，遍历的顺序以及错误的语义与Walk相同
+//
+// 与Walk不同，由调用方通过Next驱动遍历，可以随时停止、稍后继续，也方便与channel、其他遍历组合使用。
+// Walker使用显式的栈代替walk中的递归，目录层级再深也不会耗尽goroutine的栈。
+// Walker不能被多个goroutine并发使用
+//
+//	w := NewWalker(root)
+//	for w.Next() {
+//		if w.Err() != nil {
+//			// 处理错误，可以继续遍历
+//			continue
+//		}
+//		if w.Entry().IsDir() && w.Entry().Name() == ".git" {
+//			w.SkipDir()
+//		}
+//	}
+type Walker struct {
+	fsys fileSystem
+	root string
+	// Next是否已经被调用过
+	started bool
+
+	// 当前路径
+	path string
+	info os.FileInfo
+	err  error
+
+	// 当前路径是目录且读取成功时，其目录项。下一次Next时入栈，SkipDir时丢弃
+	next *walkFrame
+	// 正在遍历的目录，栈顶为当前路径所在的目录
+	stack []*walkFrame
+}
+
+// walkFrame 栈中的一个目录
+type walkFrame struct {
+	path    string
+	entries []fs.DirEntry
+	// 下一个需要遍历的目录项在entries中的索引
+	i int
+}
+
+// NewWalker 返回遍历root文件树的Walker，root不会在第一次调用Next之前被访问
+func NewWalker(root string) *Walker {
+	return &Walker{fsys: osFS{}, root: root}
+}
+
+// Next 前进到下一个路径，没有更多的路径时返回false
+//
+// 与Walk相同，目录在前进到该目录时就已经被读取，读取失败时Err返回该错误，且不会遍历其子路径
+func (w *Walker) Next() bool {
+	if !w.started {
+		w.started = true
+		info, err := w.fsys.Lstat(w.root)
+		w.visit(w.root, info, err)
+		return true
+	}
+	// 进入当前目录
+	if w.next != nil {
+		w.stack = append(w.stack, w.next)
+		w.next = nil
+	}
+	for len(w.stack) > 0 {
+		top := w.stack[len(w.stack)-1]
+		if top.i >= len(top.entries) {
+			// 目录遍历完成，出栈
+			w.stack[len(w.stack)-1] = nil
+			w.stack = w.stack[:len(w.stack)-1]
+			continue
+		}
+		entry := top.entries[top.i]
+		top.i++
+		path := Join(top.path, entry.Name())
+		info, err := w.fsys.EntryInfo(top.path, entry)
+		w.visit(path, info, err)
+		return true
+	}
+	w.path, w.info, w.err = "", nil, nil
+	return false
+}
+
+// visit 将path设置为当前路径，path是目录时读取其目录项
+// info、err为path的Lstat结果
+func (w *Walker) visit(path string, info os.FileInfo, err error) {
+	w.path, w.info, w.err = path, info, err
+	if w.err != nil || !w.info.IsDir() {
+		return
+	}
+	entries, err := w.fsys.ReadDir(path)
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.next = &walkFrame{path: path, entries: entries}
+}
+
+// Path 当前路径，与传给WalkFunc的path相同
+func (w *Walker) Path() string {
+	return w.path
+}
+
+// Entry 当前路径的fs.DirEntry，Lstat失败时为nil
+func (w *Walker) Entry() fs.DirEntry {
+	if w.info == nil {
+		return nil
+	}
+	return &statDirEntry{info: w.info}
+}
+
+// Err 当前路径的错误，与传给WalkFunc的err相同：Lstat失败，或者目录读取失败
+//
+// 出错之后仍然可以继续调用Next，是否停止由调用方决定
+func (w *Walker) Err() error {
+	return w.err
+}
+
+// SkipDir 与WalkFunc返回SkipDir的语义相同：
+// 1. 当前路径是目录，不再遍历该目录
+// 2. 当前路径是文件，不再遍历该文件所在目录中剩余的路径
+func (w *Walker) SkipDir() {
+	if w.info != nil && w.info.IsDir() {
+		w.next = nil
+		return
+	}
+	if len(w.stack) > 0 {
+		top := w.stack[len(w.stack)-1]
+		top.i = len(top.entries)
+	}
+}
@@ -16,8 +16,38 @@ var ErrBadPattern = errors.New("syntax error in pattern")
 // '?'  匹配任意一个非分割符的字符
 // []	范围匹配，内不支持'*', '?'的上述作用
 // \\	转义字符
+// **	作为一个完整的subPath时，匹配零个或多个完整的目录。比如"src/**/*_test.go"
+//		不是完整subPath的"**"与'*'相同，比如"a**b"
+// {}	多选一，以','分隔各个选项，支持嵌套。比如"*.{go,mod,sum}"、"cmd/{server,worker}/main.go"
+//		'{', '}'必须成对出现，否则返回ErrBadPattern
 //
 func Match(pattern, name string) (matched bool, err error) {
+	// 不包含'{}'时不展开，避免每次调用都分配内存
+	if strings.IndexByte(pattern, '{') < 0 && strings.IndexByte(pattern, '}') < 0 {
+		return matchExpanded(pattern, name)
+	}
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range patterns {
+		if matched, err = matchExpanded(p, name); err != nil || matched {
+			return
+		}
+	}
+	return false, nil
+}
+
+// matchExpanded 匹配已经展开'{}'的pattern
+func matchExpanded(pattern, name string) (matched bool, err error) {
+	if hasDoubleStar(pattern) {
+		return matchDoubleStar(splitSegments(pattern), splitSegments(name))
+	}
+	return match(pattern, name)
+}
+
+// match 不包含"**" subPath的匹配，'*'不会跨越分隔符
+func match(pattern, name string) (matched bool, err error) {
 Pattern:
 	for len(pattern) > 0 {
 		var startWithStar bool
@@ -208,3 +238,134 @@ func getEcs(chunk string) (r rune, nchunk string, err error) {
 	}
 	return
 }
+
+// doubleStar 匹配零个或多个目录的subPattern
+const doubleStar = "**"
+
+// hasDoubleStar 检测pattern中是否存在"**" subPath
+// Match每次都会调用，不包含"**"时不分割pattern，避免内存分配
+func hasDoubleStar(pattern string) bool {
+	if !strings.Contains(pattern, doubleStar) {
+		return false
+	}
+	for _, seg := range splitSegments(pattern) {
+		if seg == doubleStar {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSegments 以分隔符分割path为subPath列表
+// 绝对路径的第一个subPath为""，由于""也只能匹配""，所以不需要特殊处理
+func splitSegments(path string) []string {
+	return strings.Split(path, string(Separator))
+}
+
+// matchDoubleStar 逐个subPath匹配。
+// 普通的subPattern使用match匹配一个subPath, "**"则依次尝试匹配0, 1, 2...个subPath，回溯匹配剩余部分
+func matchDoubleStar(patterns, names []string) (matched bool, err error) {
+	for len(patterns) > 0 {
+		if patterns[0] != doubleStar {
+			if len(names) == 0 {
+				return false, nil
+			}
+			if matched, err = match(patterns[0], names[0]); err != nil || !matched {
+				return
+			}
+			patterns, names = patterns[1:], names[1:]
+			continue
+		}
+		// 去除多余的连续重复的"**"
+		for len(patterns) > 0 && patterns[0] == doubleStar {
+			patterns = patterns[1:]
+		}
+		// "**"是最后一个subPattern，匹配剩余所有的subPath
+		if len(patterns) == 0 {
+			return true, nil
+		}
+		for i := 0; i <= len(names); i++ {
+			if matched, err = matchDoubleStar(patterns, names[i:]); err != nil || matched {
+				return
+			}
+		}
+		return false, nil
+	}
+	// name匹配没有残留
+	return len(names) == 0, nil
+}
+
+// expandBraces 展开pattern中的'{}'，返回展开后不包含'{}'的pattern列表
+//
+// 找到第一个最外层的"{...}"，以其中最外层的','分割出各个选项，
+// 将每个选项与前缀、后缀拼接之后递归展开。嵌套的'{}'以及后缀中的'{}'都由递归处理
+// 转义的'{', '}', ','以及"[]"中的'{', '}', ','不做特殊处理
+func expandBraces(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "{}") {
+		return []string{pattern}, nil
+	}
+	start, end := -1, -1
+	// 最外层'{}'中','的位置
+	var commas []int
+	depth := 0
+	inrange := false
+Scan:
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+		case '[':
+			inrange = true
+		case ']':
+			inrange = false
+		case '{':
+			if inrange {
+				continue
+			}
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case ',':
+			if !inrange && depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if inrange {
+				continue
+			}
+			// 没有与之配对的'{'
+			if depth == 0 {
+				return nil, ErrBadPattern
+			}
+			depth--
+			if depth == 0 {
+				end = i
+				break Scan
+			}
+		}
+	}
+	// 没有与之配对的'}'
+	if depth > 0 {
+		return nil, ErrBadPattern
+	}
+	// '{', '}'都被转义或者在"[]"中
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+
+	prefix, suffix := pattern[:start], pattern[end+1:]
+	var patterns []string
+	lo := start + 1
+	for _, hi := range append(commas, end) {
+		expanded, err := expandBraces(prefix + pattern[lo:hi] + suffix)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, expanded...)
+		lo = hi + 1
+	}
+	return patterns, nil
+}
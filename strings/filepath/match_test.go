@@ -0,0 +1,810 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package filepath_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/morganxf/example/strings/filepath"
+)
+
+type MatchTest struct {
+	pattern, s string
+	match      bool
+	err        error
+}
+
+var matchTests = []MatchTest{
+	{"abc", "abc", true, nil},
+	{"*", "abc", true, nil},
+	{"*c", "abc", true, nil},
+	{"a*", "a", true, nil},
+	{"a*", "abc", true, nil},
+	{"a*", "ab/c", false, nil},
+	{"a*/b", "abc/b", true, nil},
+	{"a*/b", "a/c/b", false, nil},
+	{"a*b*c*d*e*/f", "axbxcxdxe/f", true, nil},
+	{"a*b*c*d*e*/f", "axbxcxdxexxx/f", true, nil},
+	{"a*b*c*d*e*/f", "axbxcxdxe/xxx/f", false, nil},
+	{"a*b*c*d*e*/f", "axbxcxdxexxx/fff", false, nil},
+	{"a*b?c*x", "abxbbxdbxebxczzx", true, nil},
+	{"a*b?c*x", "abxbbxdbxebxczzy", false, nil},
+	{"ab[c]", "abc", true, nil},
+	{"ab[b-d]", "abc", true, nil},
+	{"ab[e-g]", "abc", false, nil},
+	{"ab[^c]", "abc", false, nil},
+	{"ab[^b-d]", "abc", false, nil},
+	{"ab[^e-g]", "abc", true, nil},
+	{"a\\*b", "a*b", true, nil},
+	{"a\\*b", "ab", false, nil},
+	{"a?b", "a☺b", true, nil},
+	{"a[^a]b", "a☺b", true, nil},
+	{"a???b", "a☺b", false, nil},
+	{"a[^a][^a][^a]b", "a☺b", false, nil},
+	{"[a-ζ]*", "α", true, nil},
+	{"*[a-ζ]", "A", false, nil},
+	{"a?b", "a/b", false, nil},
+	{"a*b", "a/b", false, nil},
+	{"[\\]a]", "]", true, nil},
+	{"[\\-]", "-", true, nil},
+	{"[x\\-]", "x", true, nil},
+	{"[x\\-]", "-", true, nil},
+	{"[x\\-]", "z", false, nil},
+	{"[\\-x]", "x", true, nil},
+	{"[\\-x]", "-", true, nil},
+	{"[\\-x]", "a", false, nil},
+	{"[]a]", "]", false, ErrBadPattern},
+	{"[-]", "-", false, ErrBadPattern},
+	{"[x-]", "x", false, ErrBadPattern},
+	{"[x-]", "-", false, ErrBadPattern},
+	{"[x-]", "z", false, ErrBadPattern},
+	{"[-x]", "x", false, ErrBadPattern},
+	{"[-x]", "-", false, ErrBadPattern},
+	{"[-x]", "a", false, ErrBadPattern},
+	{"\\", "a", false, ErrBadPattern},
+	{"[a-b-c]", "a", false, ErrBadPattern},
+	{"[", "a", false, ErrBadPattern},
+	{"[^", "a", false, ErrBadPattern},
+	{"[^bc", "a", false, ErrBadPattern},
+	{"a[", "a", false, nil},
+	{"a[", "ab", false, ErrBadPattern},
+	{"*x", "xxx", true, nil},
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		ok, err := Match(tt.pattern, tt.s)
+		if ok != tt.match || err != tt.err {
+			t.Errorf("Match(%#q, %#q) = %v, %v want %v, %v", tt.pattern, tt.s, ok, err, tt.match, tt.err)
+		}
+	}
+}
+
+var doubleStarTests = []MatchTest{
+	{"**", "a", true, nil},
+	{"**", "a/b/c", true, nil},
+	{"a/**", "a", true, nil},
+	{"a/**", "a/b/c", true, nil},
+	{"a/**", "b/c", false, nil},
+	{"a/**/b", "a/b", true, nil},
+	{"a/**/b", "a/x/y/b", true, nil},
+	{"a/**/b", "a/x/y/c", false, nil},
+	{"a/**/**/b", "a/x/b", true, nil},
+	{"src/**/*_test.go", "src/a/b/c_test.go", true, nil},
+	{"src/**/*_test.go", "src/c_test.go", true, nil},
+	{"src/**/*_test.go", "src/a/c.go", false, nil},
+	{"**/*.go", "a.go", true, nil},
+	{"**/*.go", "a/b.go", true, nil},
+	{"/**/b", "/a/b", true, nil},
+	{"/**/b", "a/b", false, nil},
+	{"a**b", "axxb", true, nil},
+	{"a**b", "ax/xb", false, nil},
+	{"**/[", "a/b", false, ErrBadPattern},
+}
+
+func TestMatchDoubleStar(t *testing.T) {
+	for _, tt := range doubleStarTests {
+		ok, err := Match(tt.pattern, tt.s)
+		if ok != tt.match || err != tt.err {
+			t.Errorf("Match(%#q, %#q) = %v, %v want %v, %v", tt.pattern, tt.s, ok, err, tt.match, tt.err)
+		}
+	}
+}
+
+func TestGlobDoubleStar(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestGlobDoubleStar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"src/a/b", "src/c", "doc"} {
+		if err := os.MkdirAll(Join(tmpDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"src/x_test.go", "src/a/b/y_test.go", "src/a/b/y.go", "src/c/z_test.go", "doc/w_test.go"} {
+		if err := ioutil.WriteFile(Join(tmpDir, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"src/**/*_test.go", []string{"src/a/b/y_test.go", "src/c/z_test.go", "src/x_test.go"}},
+		{"*/**/*_test.go", []string{"doc/w_test.go", "src/a/b/y_test.go", "src/c/z_test.go", "src/x_test.go"}},
+		{"src/**/b", []string{"src/a/b"}},
+		{"src/a/**", []string{"src/a", "src/a/b", "src/a/b/y.go", "src/a/b/y_test.go"}},
+		{"nonexistent/**", nil},
+	}
+	for _, tt := range tests {
+		pattern := Join(tmpDir, tt.pattern)
+		matches, err := Glob(pattern)
+		if err != nil {
+			t.Errorf("Glob(%#q) error: %v", pattern, err)
+			continue
+		}
+		var got []string
+		for _, m := range matches {
+			rel, err := Rel(tmpDir, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, rel)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Glob(%#q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+var braceTests = []MatchTest{
+	{"*.{go,mod,sum}", "go.mod", true, nil},
+	{"*.{go,mod,sum}", "main.go", true, nil},
+	{"*.{go,mod,sum}", "README.md", false, nil},
+	{"cmd/{server,worker}/main.go", "cmd/worker/main.go", true, nil},
+	{"cmd/{server,worker}/main.go", "cmd/client/main.go", false, nil},
+	{"a{b,c{d,e}}f", "acef", true, nil},
+	{"a{b,c{d,e}}f", "abf", true, nil},
+	{"a{b,c{d,e}}f", "acf", false, nil},
+	{"{a,b}{c,d}", "bc", true, nil},
+	{"a{,b}", "a", true, nil},
+	{"{a/b,c}", "a/b", true, nil},
+	{"{**,x}/y", "a/b/y", true, nil},
+	{"a\\{b,c\\}", "a{b,c}", true, nil},
+	{"a\\{b,c\\}", "ab", false, nil},
+	{"a{b\\,c,d}", "ab,c", true, nil},
+	{"[{]", "{", true, nil},
+	{"[}]", "}", true, nil},
+	{"a,b", "a,b", true, nil},
+	{"{a,b", "a", false, ErrBadPattern},
+	{"a}", "a}", false, ErrBadPattern},
+	{"{a,{b}", "b", false, ErrBadPattern},
+	{"{a,b}}", "a}", false, ErrBadPattern},
+	{"{a,[}", "a", false, ErrBadPattern},
+}
+
+func TestMatchBraces(t *testing.T) {
+	for _, tt := range braceTests {
+		ok, err := Match(tt.pattern, tt.s)
+		if ok != tt.match || err != tt.err {
+			t.Errorf("Match(%#q, %#q) = %v, %v want %v, %v", tt.pattern, tt.s, ok, err, tt.match, tt.err)
+		}
+	}
+}
+
+func TestGlobBraces(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestGlobBraces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"cmd/server", "cmd/worker", "cmd/client"} {
+		if err := os.MkdirAll(Join(tmpDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"go.mod", "go.sum", "main.go", "README.md", "cmd/server/main.go", "cmd/worker/main.go", "cmd/client/main.go"} {
+		if err := ioutil.WriteFile(Join(tmpDir, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.{go,mod,sum}", []string{"main.go", "go.mod", "go.sum"}},
+		{"cmd/{server,worker}/main.go", []string{"cmd/server/main.go", "cmd/worker/main.go"}},
+		{"{cmd/*,cmd/s*}/main.go", []string{"cmd/client/main.go", "cmd/server/main.go", "cmd/worker/main.go"}},
+		{"{x,y}", nil},
+	}
+	for _, tt := range tests {
+		pattern := Join(tmpDir, tt.pattern)
+		matches, err := Glob(pattern)
+		if err != nil {
+			t.Errorf("Glob(%#q) error: %v", pattern, err)
+			continue
+		}
+		var got []string
+		for _, m := range matches {
+			rel, err := Rel(tmpDir, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, rel)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Glob(%#q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+
+	if _, err := Glob(Join(tmpDir, "{a,b")); err != ErrBadPattern {
+		t.Errorf("Glob with unbalanced braces: got error %v, want %v", err, ErrBadPattern)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	var tests []MatchTest
+	tests = append(tests, matchTests...)
+	tests = append(tests, doubleStarTests...)
+	tests = append(tests, braceTests...)
+	for _, tt := range tests {
+		p, err := Compile(tt.pattern)
+		if err != nil {
+			if tt.err == nil && tt.pattern != "a[" {
+				t.Errorf("Compile(%#q) error: %v", tt.pattern, err)
+			}
+			continue
+		}
+		if tt.err != nil {
+			t.Errorf("Compile(%#q) succeeded, want %v", tt.pattern, tt.err)
+			continue
+		}
+		if p.String() != tt.pattern {
+			t.Errorf("Compile(%#q).String() = %#q", tt.pattern, p.String())
+		}
+		if ok := p.Match(tt.s); ok != tt.match {
+			t.Errorf("Compile(%#q).Match(%#q) = %v want %v", tt.pattern, tt.s, ok, tt.match)
+		}
+	}
+
+	// Match reports the bad range lazily, Compile always does.
+	if _, err := Compile("a["); err != ErrBadPattern {
+		t.Errorf("Compile(%#q) error = %v, want %v", "a[", err, ErrBadPattern)
+	}
+}
+
+func TestMustCompile(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MustCompile(%#q) did not panic", "[")
+		}
+	}()
+	MustCompile("[")
+}
+
+func TestGlobWithOptions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestGlobWithOptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"a", "b"} {
+		if err := os.Mkdir(Join(tmpDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(Join(tmpDir, dir, "x"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Missing files are not I/O errors, even in the strict modes.
+	for _, policy := range []GlobErrorPolicy{GlobFailFast, GlobCollectErrors} {
+		for _, pattern := range []string{"nonexistent", "nonexistent/*", "a/x/*", "*/x/y"} {
+			matches, err := GlobWithOptions(Join(tmpDir, pattern), GlobOptions{ErrorPolicy: policy})
+			if matches != nil || err != nil {
+				t.Errorf("GlobWithOptions(%#q, %v) = %q, %v, want nil, nil", pattern, policy, matches, err)
+			}
+		}
+	}
+
+	if os.Getuid() == 0 {
+		t.Skip("skipping permission tests when running as root")
+	}
+	if err := os.Chmod(Join(tmpDir, "a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(Join(tmpDir, "a"), 0755)
+
+	pattern := Join(tmpDir, "*", "x")
+	matches, err := Glob(pattern)
+	if err != nil || !reflect.DeepEqual(matches, []string{Join(tmpDir, "b", "x")}) {
+		t.Errorf("Glob(%#q) = %q, %v", pattern, matches, err)
+	}
+
+	matches, err = GlobWithOptions(pattern, GlobOptions{ErrorPolicy: GlobFailFast})
+	if _, ok := err.(*os.PathError); !ok || matches != nil {
+		t.Errorf("GlobWithOptions(%#q, GlobFailFast) = %q, %v, want *os.PathError", pattern, matches, err)
+	}
+
+	matches, err = GlobWithOptions(pattern, GlobOptions{ErrorPolicy: GlobCollectErrors})
+	if !reflect.DeepEqual(matches, []string{Join(tmpDir, "b", "x")}) {
+		t.Errorf("GlobWithOptions(%#q, GlobCollectErrors) = %q", pattern, matches)
+	}
+	if errs, ok := err.(GlobErrors); !ok || len(errs) != 1 || errs[0].Path != Join(tmpDir, "a") {
+		t.Errorf("GlobWithOptions(%#q, GlobCollectErrors) error = %v, want one error for %q", pattern, err, Join(tmpDir, "a"))
+	}
+}
+
+func TestGlobFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"go.mod":               {},
+		"main.go":              {},
+		"cmd/server/main.go":   {},
+		"cmd/worker/main.go":   {},
+		"cmd/worker/worker.go": {},
+		"docs/README.md":       {},
+	}
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"main.go", []string{"main.go"}},
+		{"*.go", []string{"main.go"}},
+		{"cmd/*/main.go", []string{"cmd/server/main.go", "cmd/worker/main.go"}},
+		{"*/*/*.go", []string{"cmd/server/main.go", "cmd/worker/main.go", "cmd/worker/worker.go"}},
+		{"**/main.go", []string{"cmd/server/main.go", "cmd/worker/main.go", "main.go"}},
+		{"{docs,cmd/server}/*", []string{"docs/README.md", "cmd/server/main.go"}},
+		{"nonexistent/*", nil},
+	}
+	for _, tt := range tests {
+		matches, err := GlobFS(fsys, tt.pattern)
+		if err != nil {
+			t.Errorf("GlobFS(%#q) error: %v", tt.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(matches, tt.want) {
+			t.Errorf("GlobFS(%#q) = %q, want %q", tt.pattern, matches, tt.want)
+		}
+	}
+	if _, err := GlobFS(fsys, "["); err != ErrBadPattern {
+		t.Errorf("GlobFS(%#q) error = %v, want %v", "[", err, ErrBadPattern)
+	}
+}
+
+func TestGlobContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, pattern := range []string{"*", "*/*", "**/*.go"} {
+		matches, err := GlobContext(ctx, pattern)
+		if matches != nil || err != context.Canceled {
+			t.Errorf("GlobContext(%#q) = %q, %v, want nil, %v", pattern, matches, err, context.Canceled)
+		}
+	}
+
+	matches, err := GlobContext(context.Background(), "match_test.go")
+	if err != nil || !reflect.DeepEqual(matches, []string{"match_test.go"}) {
+		t.Errorf("GlobContext(%#q) = %q, %v", "match_test.go", matches, err)
+	}
+}
+
+func TestGlobUnsorted(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestGlobUnsorted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for i := 0; i < 100; i++ {
+		dir := Join(tmpDir, fmt.Sprintf("d%02d", i%3))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(Join(dir, fmt.Sprintf("f%02d.txt", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pattern := range []string{"*/f*.txt", "d0[12]/*", "**/f1*", "nonexistent/*"} {
+		want, err := Glob(Join(tmpDir, pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, batch := range []int{0, 1, 7} {
+			opts := GlobOptions{Unsorted: true, BatchSize: batch}
+			matches, err := GlobWithOptions(Join(tmpDir, pattern), opts)
+			if err != nil {
+				t.Errorf("GlobWithOptions(%#q, %+v) error: %v", pattern, opts, err)
+				continue
+			}
+			sort.Strings(matches)
+			if !reflect.DeepEqual(matches, want) {
+				t.Errorf("GlobWithOptions(%#q, %+v) = %q, want %q", pattern, opts, matches, want)
+			}
+		}
+	}
+}
+
+func TestGlobFunc(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestGlobFunc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"a/x", "a/y", "b/x", "b/c/x"} {
+		if err := os.MkdirAll(Join(tmpDir, Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(Join(tmpDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pattern := range []string{"*/x", "**/x", "{a,b}/*", "{a,*}/x"} {
+		want, err := Glob(Join(tmpDir, pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		err = GlobFunc(Join(tmpDir, pattern), GlobOptions{}, func(match string) error {
+			got = append(got, match)
+			return nil
+		})
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("GlobFunc(%#q) = %q, %v, want %q", pattern, got, err, want)
+		}
+
+		// SkipAll stops after the first match without an error.
+		got = nil
+		err = GlobFunc(Join(tmpDir, pattern), GlobOptions{}, func(match string) error {
+			got = append(got, match)
+			return SkipAll
+		})
+		if err != nil || !reflect.DeepEqual(got, want[:1]) {
+			t.Errorf("GlobFunc(%#q) with SkipAll = %q, %v, want %q", pattern, got, err, want[:1])
+		}
+
+		// SkipDir is an ordinary error: it stops matching and is returned as is.
+		got = nil
+		err = GlobFunc(Join(tmpDir, pattern), GlobOptions{}, func(match string) error {
+			got = append(got, match)
+			return SkipDir
+		})
+		if err != SkipDir || !reflect.DeepEqual(got, want[:1]) {
+			t.Errorf("GlobFunc(%#q) with SkipDir = %q, %v, want %q, %v", pattern, got, err, want[:1], SkipDir)
+		}
+	}
+
+	errStop := errors.New("stop")
+	for _, pattern := range []string{"*/x", "**/x"} {
+		err = GlobFunc(Join(tmpDir, pattern), GlobOptions{}, func(match string) error {
+			return errStop
+		})
+		if err != errStop {
+			t.Errorf("GlobFunc(%#q) error = %v, want %v", pattern, err, errStop)
+		}
+	}
+}
+
+// A callback returning SkipDir on a "**" match must not prune the rest
+// of the directory and then lose the error.
+func TestGlobFuncDoubleStarSkipDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestGlobFuncDoubleStarSkipDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"src/a/1.go", "src/a/2.go", "src/b.go"} {
+		if err := os.MkdirAll(Join(tmpDir, Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(Join(tmpDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pattern := Join(tmpDir, "src/**/*.go")
+	var got []string
+	err = GlobFunc(pattern, GlobOptions{}, func(match string) error {
+		got = append(got, match)
+		if Base(match) == "1.go" {
+			return SkipDir
+		}
+		return nil
+	})
+	want := []string{Join(tmpDir, "src/a/1.go")}
+	if err != SkipDir || !reflect.DeepEqual(got, want) {
+		t.Errorf("GlobFunc(%#q) = %q, %v, want %q, %v", pattern, got, err, want, SkipDir)
+	}
+}
+
+// A directory that fails partway through an Unsorted read is matched by
+// "**" only once.
+func TestGlobDoubleStarReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	fsys := failingDirFS{
+		MapFS: fstest.MapFS{"d/a": {}, "d/b": {}, "d/c": {}, "e/f": {}},
+		dir:   "d",
+		err:   errRead,
+	}
+	opts := GlobOptions{ErrorPolicy: GlobCollectErrors, Unsorted: true, BatchSize: 1}
+	matches, err := GlobWithOptionsFS(fsys, "**", opts)
+	want := []string{"d", "d/a", "e", "e/f"}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("GlobWithOptionsFS(**) = %q, want %q", matches, want)
+	}
+	errs, ok := err.(GlobErrors)
+	if !ok || len(errs) != 1 || errs[0].Err != errRead {
+		t.Errorf("GlobWithOptionsFS(**) error = %v, want one %v", err, errRead)
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	m := NewIgnoreMatcher()
+	m.AddPatterns("",
+		"# comment",
+		"",
+		"*.log",
+		"!keep.log",
+		"/build",
+		"tmp/",
+		"docs/**/*.pdf",
+		"vendor/**",
+		"!vendor/keep",
+		"**/cache",
+		"\\#hash",
+		"trailing   ",
+		"escaped\\ ",
+		"[!a]x",
+		"{a,b}",
+		"bad[",
+	)
+	m.AddPatterns("sub", "*.txt", "/only", "!important.log")
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"app.log", false, true},
+		{"a/b/app.log", false, true},
+		{"keep.log", false, false},
+		{"a/keep.log", false, false},
+		{"build", true, true},
+		{"build/x", false, true},
+		{"a/build", true, false},
+		{"tmp", true, true},
+		{"tmp", false, false},
+		{"a/tmp/x", false, true},
+		{"docs/a.pdf", false, true},
+		{"docs/a/b/c.pdf", false, true},
+		{"a/docs/a.pdf", false, false},
+		{"vendor", true, false},
+		{"vendor/x", false, true},
+		{"vendor/keep", false, false},
+		{"vendor/a/b", false, true},
+		{"cache", true, true},
+		{"a/b/cache", false, true},
+		{"#hash", false, true},
+		{"trailing", false, true},
+		{"escaped ", false, true},
+		{"escaped", false, false},
+		{"bx", false, true},
+		{"ax", false, false},
+		{"{a,b}", false, true},
+		{"a", false, false},
+		{"bad[", false, false},
+		{"sub/a.txt", false, true},
+		{"sub/x/a.txt", false, true},
+		{"a.txt", false, false},
+		{"sub/only", false, true},
+		{"sub/x/only", false, false},
+		{"sub/important.log", false, false},
+		{"sub/x/important.log", false, false},
+		{"sub/other.log", false, true},
+		{".", true, false},
+	}
+	for _, tt := range tests {
+		if got := m.Ignored(tt.path, tt.isDir); got != tt.ignored {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.ignored)
+		}
+	}
+
+	if err := m.Add("other", strings.NewReader("*.o\r\n!main.o\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Ignored("other/a.o", false) || m.Ignored("other/main.o", false) || m.Ignored("a.o", false) {
+		t.Errorf("rules added with Add are not applied")
+	}
+}
+
+func TestRegexpString(t *testing.T) {
+	var tests []MatchTest
+	tests = append(tests, matchTests...)
+	tests = append(tests, doubleStarTests...)
+	tests = append(tests, braceTests...)
+	for _, tt := range tests {
+		s, err := RegexpString(tt.pattern)
+		if err != nil {
+			// Match reports some bad patterns lazily, RegexpString always does.
+			if err != ErrBadPattern {
+				t.Errorf("RegexpString(%#q) error = %v, want %v", tt.pattern, err, ErrBadPattern)
+			}
+			if tt.err == nil && tt.match {
+				t.Errorf("RegexpString(%#q) failed, but Match(%#q, %#q) succeeds", tt.pattern, tt.pattern, tt.s)
+			}
+			continue
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			t.Errorf("RegexpString(%#q) = %#q, which does not compile: %v", tt.pattern, s, err)
+			continue
+		}
+		if got := re.MatchString(tt.s); got != tt.match {
+			t.Errorf("RegexpString(%#q) = %#q, MatchString(%#q) = %v, want %v", tt.pattern, s, tt.s, got, tt.match)
+		}
+	}
+
+	// Ranges in a "**" pattern match a single path element and never '/'.
+	for _, tt := range []MatchTest{
+		{"/**/[^a]", "/b//", false, nil},
+		{"/**/[^a]", "/b/c", true, nil},
+		{"**/[.-0]", "a/.", true, nil},
+		{"**/[.-0]", "a//", false, nil},
+		{"a/**/[^b]x", "a//x", false, nil},
+		// Reversed ranges match nothing, and nothing is left to negate.
+		{"**/[z-a]", "a/a", false, nil},
+		{"**/[^z-a]", "a/a", true, nil},
+		{"**/[^z-a]", "a//", false, nil},
+	} {
+		s, err := RegexpString(tt.pattern)
+		if err != nil {
+			t.Errorf("RegexpString(%#q) error = %v", tt.pattern, err)
+			continue
+		}
+		if got := regexp.MustCompile(s).MatchString(tt.s); got != tt.match {
+			t.Errorf("RegexpString(%#q) = %#q, MatchString(%#q) = %v, want %v", tt.pattern, s, tt.s, got, tt.match)
+		}
+		if got, _ := Match(tt.pattern, tt.s); got != tt.match {
+			t.Errorf("Match(%#q, %#q) = %v, want %v", tt.pattern, tt.s, got, tt.match)
+		}
+	}
+
+	// Reversed ranges are accepted by Compile and must still give a regexp
+	// that compiles.
+	for _, tt := range []MatchTest{
+		{"[b-a]", "a", false, nil},
+		{"[b-a]", "b", false, nil},
+		{"[^b-a]", "a", true, nil},
+		{"[^b-a]", "/", true, nil},
+		{"[a-*]", "a", false, nil},
+		{"[{-a]", "a", false, nil},
+		{"x[b-ac]", "xc", true, nil},
+	} {
+		s, err := RegexpString(tt.pattern)
+		if err != nil {
+			t.Errorf("RegexpString(%#q) error = %v", tt.pattern, err)
+			continue
+		}
+		if got := regexp.MustCompile(s).MatchString(tt.s); got != tt.match {
+			t.Errorf("RegexpString(%#q) = %#q, MatchString(%#q) = %v, want %v", tt.pattern, s, tt.s, got, tt.match)
+		}
+		if got, _ := Match(tt.pattern, tt.s); got != tt.match {
+			t.Errorf("Match(%#q, %#q) = %v, want %v", tt.pattern, tt.s, got, tt.match)
+		}
+	}
+
+	// The documented difference: Match does not backtrack into a '*' once
+	// a following non-final chunk that can match '/' has matched.
+	for _, tt := range []struct{ pattern, s string }{
+		{"*[^a]*", "ba/,"},
+	} {
+		if got, _ := Match(tt.pattern, tt.s); got {
+			t.Errorf("Match(%#q, %#q) = true, want false", tt.pattern, tt.s)
+		}
+		s := MustCompile(tt.pattern).RegexpString()
+		if !regexp.MustCompile(s).MatchString(tt.s) {
+			t.Errorf("RegexpString(%#q) = %#q, does not match %#q", tt.pattern, s, tt.s)
+		}
+	}
+}
+
+func TestSplitPattern(t *testing.T) {
+	tests := []struct {
+		pattern, dir, rest string
+	}{
+		{"var/log/app-*/2024/*.gz", "var/log", "app-*/2024/*.gz"},
+		{"/*/bin", "/", "*/bin"},
+		{"/var/*", "/var", "*"},
+		{"a/b", "a", "b"},
+		{"a/b/", "a/b", ""},
+		{"*.go", "", "*.go"},
+		{"", "", ""},
+		{`a\*b/c/?`, "a*b/c", "?"},
+		{`a\`, "", `a\`},
+		{"a/{b,c}/d", "a", "{b,c}/d"},
+		{"src/**/*.go", "src", "**/*.go"},
+		{"a/[bc]/d", "a", "[bc]/d"},
+	}
+	for _, tt := range tests {
+		dir, rest := SplitPattern(tt.pattern)
+		if dir != tt.dir || rest != tt.rest {
+			t.Errorf("SplitPattern(%#q) = %#q, %#q, want %#q, %#q", tt.pattern, dir, rest, tt.dir, tt.rest)
+		}
+	}
+}
+
+// readDirFS records the directories read through it.
+type readDirFS struct {
+	fstest.MapFS
+	reads *[]string
+}
+
+func (fsys readDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	*fsys.reads = append(*fsys.reads, name)
+	return fsys.MapFS.ReadDir(name)
+}
+
+func TestGlobLiteralPrefix(t *testing.T) {
+	fsys := fstest.MapFS{
+		"var/log/app-1/2024/a.gz": {},
+		"var/log/app-1/2023/b.gz": {},
+		"var/log/app-2/2024/c.gz": {},
+		"var/log/db/2024/d.gz":    {},
+		"var/lib/x":               {},
+		"a*b/x":                   {},
+		"a*b/y":                   {},
+		"axb/z":                   {},
+	}
+	tests := []struct {
+		pattern string
+		want    []string
+		reads   []string
+	}{
+		{
+			"var/log/app-*/2024/*.gz",
+			[]string{"var/log/app-1/2024/a.gz", "var/log/app-2/2024/c.gz"},
+			[]string{"var/log", "var/log/app-1", "var/log/app-1/2024", "var/log/app-2", "var/log/app-2/2024"},
+		},
+		{
+			"var/log/*/2024/d.gz",
+			[]string{"var/log/db/2024/d.gz"},
+			[]string{"var/log", "var/log/app-1", "var/log/app-1/2024", "var/log/app-2", "var/log/app-2/2024", "var/log/db", "var/log/db/2024"},
+		},
+		{
+			`a\*b/*`,
+			[]string{"a*b/x", "a*b/y"},
+			[]string{"a*b"},
+		},
+	}
+	for _, tt := range tests {
+		var reads []string
+		matches, err := GlobFS(readDirFS{fsys, &reads}, tt.pattern)
+		if err != nil || !reflect.DeepEqual(matches, tt.want) {
+			t.Errorf("GlobFS(%#q) = %q, %v, want %q", tt.pattern, matches, err, tt.want)
+		}
+		if !reflect.DeepEqual(reads, tt.reads) {
+			t.Errorf("GlobFS(%#q) read %q, want %q", tt.pattern, reads, tt.reads)
+		}
+	}
+}
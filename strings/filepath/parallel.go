@@ -0,0 +1,464 @@
+package filepath
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelWalkOptions ParallelWalk的选项
+type ParallelWalkOptions struct {
+	// Workers 同时读取目录的goroutine数量，包括调用ParallelWalk的goroutine。
+	// <=0时使用runtime.NumCPU()，1时等价于Walk
+	Workers int
+	// Serialize 为true时walkFn不会被并发调用，但是调用的顺序不确定
+	Serialize bool
+	// Ordered 为true时walkFn按照与Walk完全相同的顺序串行调用，目录仍然由多个goroutine并发预读
+	Ordered bool
+	// MaxPrefetch Ordered时最多预读、但是还没有被遍历的目录数量，达到上限时预读的goroutine等待walkFn跟上。
+	// <=0时使用defaultMaxPrefetch
+	MaxPrefetch int
+}
+
+// defaultMaxPrefetch Ordered时默认最多预读的目录数量
+const defaultMaxPrefetch = 256
+
+// ParallelWalk 与Walk相同，遍历root文件树并调用walkFn，但是使用多个goroutine并发读取目录
+//
+// walkFn的参数以及SkipDir的语义与Walk相同。默认情况下walkFn会被并发调用：
+// 同一个目录下的文件按字典序在同一个goroutine中调用，不同的目录之间则是并发的
+//
+// 错误的传递是确定的：同一个目录中，ParallelWalk总是返回按字典序第一个出错的子路径的错误，与Walk返回的错误相同。
+// 某个子路径出错或者文件返回SkipDir之后，该目录中字典序在其之后的子路径不再遍历
+//
+// walkFn返回SkipAll时，所有的goroutine都会尽快停止，正在进行的walkFn调用仍然会完成。
+// 如果字典序在其之前的路径出错，返回该错误，否则返回nil
+func ParallelWalk(root string, opts ParallelWalkOptions, walkFn WalkFunc) error {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if opts.Serialize && !opts.Ordered {
+		var mu sync.Mutex
+		fn := walkFn
+		walkFn = func(path string, info os.FileInfo, err error) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(path, info, err)
+		}
+	}
+
+	info, err := lstat(root)
+	switch {
+	case err != nil:
+		err = walkFn(root, nil, err)
+	case !info.IsDir():
+		err = walkFn(root, info, nil)
+	case opts.Ordered:
+		err = walkOrdered(osFS{}, root, info, workers, opts.MaxPrefetch, walkFn)
+	default:
+		w := &parallelWalker{
+			fsys:   osFS{},
+			walkFn: walkFn,
+			// 调用方goroutine本身也是一个worker
+			sem: make(chan struct{}, workers-1),
+		}
+		err = w.walk(newParallelDir(nil, 0), root, info)
+	}
+	// SkipDir、SkipAll不认为是一个错误
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+// parallelWalker 无序模式的并发遍历
+//
+// 每个目录在读取之后，按字典序依次处理子路径：文件直接调用walkFn，子目录尝试交给新的goroutine处理，
+// 如果goroutine数量已经达到上限，则在当前goroutine中递归处理。因此goroutine数量不会超过Workers
+type parallelWalker struct {
+	fsys   fileSystem
+	walkFn WalkFunc
+	sem    chan struct{}
+	// walkFn返回过SkipAll时为1，停止所有的遍历
+	aborted int32
+}
+
+// parallelDir 记录一个目录中第一个出错的子路径，用于停止遍历字典序在其之后的子路径
+type parallelDir struct {
+	parent *parallelDir
+	// 在parent中的索引
+	index int
+
+	mu   sync.Mutex
+	stop int
+}
+
+func newParallelDir(parent *parallelDir, index int) *parallelDir {
+	return &parallelDir{parent: parent, index: index, stop: math.MaxInt32}
+}
+
+// stopAt 第i个子路径出错，停止遍历其之后的子路径
+func (d *parallelDir) stopAt(i int) {
+	d.mu.Lock()
+	if i < d.stop {
+		d.stop = i
+	}
+	d.mu.Unlock()
+}
+
+// stopped 第i个子路径是否需要停止遍历。
+// 任意一个祖先目录在该路径之前已经出错，都需要停止
+func (d *parallelDir) stopped(i int) bool {
+	for ; d != nil; i, d = d.index, d.parent {
+		d.mu.Lock()
+		stop := d.stop
+		d.mu.Unlock()
+		if stop < i {
+			return true
+		}
+	}
+	return false
+}
+
+// walk 与walk相同的逻辑，info为目录
+func (w *parallelWalker) walk(d *parallelDir, path string, info os.FileInfo) error {
+	if atomic.LoadInt32(&w.aborted) != 0 {
+		return nil
+	}
+	entries, err := w.fsys.ReadDir(path)
+	if err != nil {
+		return w.walkFn(path, info, err)
+	}
+	if err := w.walkFn(path, info, nil); err != nil {
+		return err
+	}
+
+	// 按照子路径的索引记录错误，所有子路径结束之后返回第一个错误
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		if d.stopped(i) || atomic.LoadInt32(&w.aborted) != 0 {
+			break
+		}
+		filename := Join(path, entry.Name())
+		fileInfo, err := w.fsys.EntryInfo(path, entry)
+		if err != nil {
+			if err := w.walkFn(filename, fileInfo, err); err != nil && err != SkipDir {
+				w.stopAt(d, i, errs, err)
+			}
+			continue
+		}
+		if !fileInfo.IsDir() {
+			// 文件返回SkipDir，skip本目录中剩余的文件
+			if err := w.walkFn(filename, fileInfo, nil); err != nil {
+				w.stopAt(d, i, errs, err)
+			}
+			continue
+		}
+
+		i := i
+		child := newParallelDir(d, i)
+		walkChild := func() {
+			// 子目录返回SkipDir，只跳过该子目录
+			if err := w.walk(child, filename, fileInfo); err != nil && err != SkipDir {
+				w.stopAt(d, i, errs, err)
+			}
+		}
+		select {
+		case w.sem <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-w.sem
+					wg.Done()
+				}()
+				walkChild()
+			}()
+		default:
+			walkChild()
+		}
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopAt 记录目录d中第i个子路径的错误，SkipAll时停止所有的遍历
+func (w *parallelWalker) stopAt(d *parallelDir, i int, errs []error, err error) {
+	errs[i] = err
+	d.stopAt(i)
+	if err == SkipAll {
+		atomic.StoreInt32(&w.aborted, 1)
+	}
+}
+
+// prefetchDir的读取状态
+const (
+	dirPending int32 = iota
+	dirReading
+	dirRead
+)
+
+// prefetchDir 有序模式中被预读的目录
+type prefetchDir struct {
+	parent *prefetchDir
+	path   string
+	// 读取状态，保证每个目录只被读取一次
+	state int32
+	// 读取完成之后close
+	done chan struct{}
+	// 遍历结束或者被SkipDir跳过之后，其子目录都不再需要预读
+	consumed int32
+	// 为1时由worker预读，占用orderedWalker.ahead中的一个位置，直到被遍历或者被丢弃
+	prefetched int32
+
+	// ReadDir的错误
+	err   error
+	names []string
+	// 与names对应的Lstat结果
+	infos     []os.FileInfo
+	lstatErrs []error
+	// 与names对应的子目录，不是目录时为nil
+	children []*prefetchDir
+}
+
+func newPrefetchDir(parent *prefetchDir, path string) *prefetchDir {
+	return &prefetchDir{parent: parent, path: path, done: make(chan struct{})}
+}
+
+// isConsumed 本目录或者任意一个祖先目录已经不再需要
+func (d *prefetchDir) isConsumed() bool {
+	for ; d != nil; d = d.parent {
+		if atomic.LoadInt32(&d.consumed) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dirStack 等待预读的目录。
+// 使用栈而不是队列，使得预读的顺序接近深度优先的遍历顺序，先预读最先被需要的目录
+type dirStack struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	dirs   []*prefetchDir
+	closed bool
+}
+
+func newDirStack() *dirStack {
+	s := &dirStack{}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// pushChildren 逆序入栈，保证字典序最小的子目录最先出栈
+func (s *dirStack) pushChildren(d *prefetchDir) {
+	s.mu.Lock()
+	for i := len(d.children) - 1; i >= 0; i-- {
+		if d.children[i] != nil {
+			s.dirs = append(s.dirs, d.children[i])
+		}
+	}
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// pop 栈为空时阻塞，close之后返回nil
+func (s *dirStack) pop() *prefetchDir {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.dirs) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return nil
+	}
+	d := s.dirs[len(s.dirs)-1]
+	s.dirs[len(s.dirs)-1] = nil
+	s.dirs = s.dirs[:len(s.dirs)-1]
+	return d
+}
+
+func (s *dirStack) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// orderedWalker 有序模式的并发遍历
+//
+// 后台的worker按照近似深度优先的顺序预读目录，调用方goroutine按照与walk完全相同的顺序串行调用walkFn。
+// 需要的目录还没有被预读时，调用方goroutine自己读取，而不是等待。
+// 预读的目录数量以ahead限制，避免walkFn较慢时整个文件树都被读入内存
+type orderedWalker struct {
+	fsys   fileSystem
+	walkFn WalkFunc
+	stack  *dirStack
+	// 已经预读、还没有被遍历或者丢弃的目录，每个占用一个位置
+	ahead chan struct{}
+	// 遍历结束之后close，唤醒等待ahead的worker
+	quit chan struct{}
+}
+
+func walkOrdered(fsys fileSystem, root string, info os.FileInfo, workers, maxPrefetch int, walkFn WalkFunc) error {
+	if maxPrefetch <= 0 {
+		maxPrefetch = defaultMaxPrefetch
+	}
+	w := &orderedWalker{
+		fsys:   fsys,
+		walkFn: walkFn,
+		stack:  newDirStack(),
+		ahead:  make(chan struct{}, maxPrefetch),
+		quit:   make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers-1; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.prefetch()
+		}()
+	}
+	err := w.walk(newPrefetchDir(nil, root), info)
+	// 遍历结束，停止所有的worker
+	w.stack.close()
+	close(w.quit)
+	wg.Wait()
+	return err
+}
+
+// prefetch worker的主循环
+func (w *orderedWalker) prefetch() {
+	for {
+		d := w.stack.pop()
+		if d == nil {
+			return
+		}
+		if d.isConsumed() {
+			continue
+		}
+		// 必须在开始读取之前占用位置：调用方goroutine可能在等待正在读取的目录，而位置只能由调用方goroutine释放
+		select {
+		case w.ahead <- struct{}{}:
+		case <-w.quit:
+			return
+		}
+		if d.isConsumed() || !atomic.CompareAndSwapInt32(&d.state, dirPending, dirReading) {
+			<-w.ahead
+			continue
+		}
+		atomic.StoreInt32(&d.prefetched, 1)
+		w.read(d)
+		// 读取期间祖先目录已经遍历结束或者被跳过，d不会再被fetch，discard时也可能还没有读取完成
+		if d.isConsumed() {
+			w.release(d)
+		}
+	}
+}
+
+// release 释放worker预读d时占用的位置，可以重复调用
+func (w *orderedWalker) release(d *prefetchDir) {
+	if atomic.CompareAndSwapInt32(&d.prefetched, 1, 0) {
+		<-w.ahead
+	}
+}
+
+// discard d已经不再需要，释放其中没有被遍历的子目录中已经预读的部分
+// 正在读取的目录由worker在读取完成之后释放
+func (w *orderedWalker) discard(d *prefetchDir) {
+	for _, child := range d.children {
+		if child == nil || atomic.LoadInt32(&child.state) != dirRead {
+			continue
+		}
+		w.release(child)
+		w.discard(child)
+	}
+}
+
+// fetch 等待d读取完成。如果d还没有被worker读取，则自己读取
+// d开始被遍历，不再计入预读的目录
+func (w *orderedWalker) fetch(d *prefetchDir) {
+	if atomic.CompareAndSwapInt32(&d.state, dirPending, dirReading) {
+		w.read(d)
+		return
+	}
+	<-d.done
+	w.release(d)
+}
+
+// read 读取目录以及各个子路径的Lstat结果，并将子目录交给worker预读
+// 与walk相同，ReadDir出错时不再Lstat子路径
+func (w *orderedWalker) read(d *prefetchDir) {
+	defer func() {
+		atomic.StoreInt32(&d.state, dirRead)
+		close(d.done)
+	}()
+	entries, err := w.fsys.ReadDir(d.path)
+	if err != nil {
+		d.err = err
+		return
+	}
+	d.names = make([]string, len(entries))
+	d.infos = make([]os.FileInfo, len(entries))
+	d.lstatErrs = make([]error, len(entries))
+	d.children = make([]*prefetchDir, len(entries))
+	for i, entry := range entries {
+		d.names[i] = entry.Name()
+		filename := Join(d.path, d.names[i])
+		d.infos[i], d.lstatErrs[i] = w.fsys.EntryInfo(d.path, entry)
+		if d.lstatErrs[i] == nil && d.infos[i].IsDir() {
+			d.children[i] = newPrefetchDir(d, filename)
+		}
+	}
+	// 必须在close(d.done)之前入栈，之后d.children会被调用方goroutine修改
+	w.stack.pushChildren(d)
+}
+
+// walk 与walk相同的逻辑，info为目录
+func (w *orderedWalker) walk(d *prefetchDir, info os.FileInfo) error {
+	// 无论以何种方式返回，d的子目录都不再需要预读，已经预读但是没有被遍历的部分需要释放
+	defer func() {
+		atomic.StoreInt32(&d.consumed, 1)
+		w.discard(d)
+	}()
+
+	w.fetch(d)
+	if d.err != nil {
+		return w.walkFn(d.path, info, d.err)
+	}
+	if err := w.walkFn(d.path, info, nil); err != nil {
+		return err
+	}
+
+	for i, name := range d.names {
+		filename := Join(d.path, name)
+		if d.lstatErrs[i] != nil {
+			if err := w.walkFn(filename, d.infos[i], d.lstatErrs[i]); err != nil && err != SkipDir {
+				return err
+			}
+			continue
+		}
+		if d.children[i] == nil {
+			// 文件返回SkipDir，skip本目录中剩余的文件
+			if err := w.walkFn(filename, d.infos[i], nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.walk(d.children[i], d.infos[i]); err != nil && err != SkipDir {
+			return err
+		}
+		// 子目录已经遍历完成，释放其预读的数据
+		d.children[i] = nil
+	}
+	return nil
+}
@@ -2,21 +2,27 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package filepath
+package filepath_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/morganxf/example/strings/filepath"
 	"github.com/rogpeppe/go-internal/testenv"
 )
 
@@ -621,6 +627,1014 @@ func TestWalkFileError(t *testing.T) {
 	}
 }
 
+func TestWalkDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestWalkDir")
+	if err != nil {
+		t.Fatal("creating temp dir:", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal("finding working dir:", err)
+	}
+	if err = os.Chdir(tmpDir); err != nil {
+		t.Fatal("entering temp dir:", err)
+	}
+	defer os.Chdir(origDir)
+
+	makeTree(t)
+	var want []string
+	err = filepath.Walk(tree.name, func(path string, info os.FileInfo, err error) error {
+		want = append(want, path)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+
+	var got []string
+	err = filepath.WalkDir(tree.name, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() != filepath.Base(path) {
+			t.Errorf("WalkDir: %q has entry name %q", path, d.Name())
+		}
+		got = append(got, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkDir visited %q, want %q", got, want)
+	}
+
+	// SkipDir on a directory skips only that directory, on a file skips
+	// the remaining siblings.
+	got = got[:0]
+	err = filepath.WalkDir(tree.name, func(path string, d fs.DirEntry, err error) error {
+		got = append(got, path)
+		switch d.Name() {
+		case "b", "x":
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkDir: %v", err)
+	}
+	want = []string{"testdata", "testdata/a", "testdata/b", "testdata/c", "testdata/d", "testdata/d/x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkDir with SkipDir visited %q, want %q", got, want)
+	}
+}
+
+func TestWalkFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a":     {},
+		"b/c":   {},
+		"b/d/e": {},
+		"f":     {},
+	}
+	var got []string
+	err := filepath.WalkFS(fsys, ".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, path)
+		if path == "b/d" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkFS: %v", err)
+	}
+	want := []string{".", "a", "b", "b/c", "b/d", "f"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkFS visited %q, want %q", got, want)
+	}
+
+	err = filepath.WalkFS(fsys, "nonexistent", func(path string, info os.FileInfo, err error) error {
+		return err
+	})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("WalkFS(nonexistent) error = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+// WalkFS, like Walk, must not descend into symlinks to directories,
+// even though io/fs has no Lstat.
+func TestWalkFSSymlinkLoop(t *testing.T) {
+	testenv.MustHaveSymlink(t)
+
+	tmpDir, err := ioutil.TempDir("", "TestWalkFSSymlinkLoop")
+	if err != nil {
+		t.Fatal("creating temp dir:", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	root := filepath.Join(tmpDir, "root")
+	for _, dir := range []string{filepath.Join(tmpDir, "real", "sub"), filepath.Join(root, "sub")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "real", "sub", "x"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../real", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(root, "sub", "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = filepath.WalkFS(os.DirFS(root), ".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == "link" || path == "sub/up" {
+			if info.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("WalkFS: %s mode = %v, want symlink", path, info.Mode())
+			}
+		}
+		got = append(got, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkFS: %v", err)
+	}
+	want := []string{".", "link", "sub", "sub/up"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkFS visited %q, want %q", got, want)
+	}
+
+	matches, err := filepath.GlobFS(os.DirFS(root), "**/x")
+	if err != nil {
+		t.Fatalf("GlobFS: %v", err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("GlobFS(**/x) = %q, want none", matches)
+	}
+}
+
+func TestWalkContext(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestWalkContext")
+	if err != nil {
+		t.Fatal("creating temp dir:", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal("finding working dir:", err)
+	}
+	if err = os.Chdir(tmpDir); err != nil {
+		t.Fatal("entering temp dir:", err)
+	}
+	defer os.Chdir(origDir)
+
+	makeTree(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var visited []string
+	err = filepath.WalkContext(ctx, tree.name, func(path string, info os.FileInfo, err error) error {
+		visited = append(visited, path)
+		// Cancel after the first entry: files already listed are still
+		// reported, but the walk stops before reading the next directory.
+		cancel()
+		return err
+	})
+	if err != context.Canceled {
+		t.Errorf("WalkContext error = %v, want %v", err, context.Canceled)
+	}
+	want := []string{"testdata", "testdata/a"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("WalkContext visited %q, want %q", visited, want)
+	}
+}
+
+// recordVisitor records every callback as "enter path", "file path" or
+// "leave path", with paths relative to root. EnterDir with a read error
+// is recorded as "error path".
+type recordVisitor struct {
+	root   string
+	events []string
+	// ret returns the error for the callback, keyed by the event.
+	ret map[string]error
+}
+
+func (v *recordVisitor) record(kind, path string) error {
+	rel, _ := filepath.Rel(v.root, path)
+	ev := kind + " " + rel
+	v.events = append(v.events, ev)
+	return v.ret[ev]
+}
+
+func (v *recordVisitor) EnterDir(path string, info os.FileInfo, err error) error {
+	if err != nil {
+		return v.record("error", path)
+	}
+	return v.record("enter", path)
+}
+
+func (v *recordVisitor) File(path string, info os.FileInfo, err error) error {
+	if err != nil {
+		return err
+	}
+	return v.record("file", path)
+}
+
+func (v *recordVisitor) LeaveDir(path string, info os.FileInfo) error {
+	return v.record("leave", path)
+}
+
+func TestWalkVisitor(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestWalkVisitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	for _, d := range []string{"a/1", "b"} {
+		if err := os.MkdirAll(filepath.Join(td, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"a/1/x", "a/1/y", "a/file", "b/x"} {
+		touch(t, filepath.Join(td, f))
+	}
+
+	errStop := errors.New("stop")
+	tests := []struct {
+		name    string
+		opts    filepath.WalkOptions
+		ret     map[string]error
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "all",
+			want: []string{
+				"enter .", "enter a", "enter a/1", "file a/1/x", "file a/1/y", "leave a/1",
+				"file a/file", "leave a", "enter b", "file b/x", "leave b", "leave .",
+			},
+		},
+		{
+			name: "EnterDir SkipDir",
+			ret:  map[string]error{"enter a": filepath.SkipDir},
+			want: []string{"enter .", "enter a", "enter b", "file b/x", "leave b", "leave ."},
+		},
+		{
+			// The rest of a/1 is skipped, but a/1 is still left.
+			name: "File SkipDir",
+			ret:  map[string]error{"file a/1/x": filepath.SkipDir},
+			want: []string{
+				"enter .", "enter a", "enter a/1", "file a/1/x", "leave a/1",
+				"file a/file", "leave a", "enter b", "file b/x", "leave b", "leave .",
+			},
+		},
+		{
+			name: "LeaveDir SkipDir",
+			ret:  map[string]error{"leave a/1": filepath.SkipDir},
+			want: []string{
+				"enter .", "enter a", "enter a/1", "file a/1/x", "file a/1/y", "leave a/1",
+				"file a/file", "leave a", "enter b", "file b/x", "leave b", "leave .",
+			},
+		},
+		{
+			name:    "File error",
+			ret:     map[string]error{"file a/1/y": errStop},
+			want:    []string{"enter .", "enter a", "enter a/1", "file a/1/x", "file a/1/y"},
+			wantErr: errStop,
+		},
+		{
+			name:    "LeaveDir error",
+			ret:     map[string]error{"leave a": errStop},
+			want:    []string{"enter .", "enter a", "enter a/1", "file a/1/x", "file a/1/y", "leave a/1", "file a/file", "leave a"},
+			wantErr: errStop,
+		},
+		{
+			name: "MaxDepth",
+			opts: filepath.WalkOptions{MaxDepth: 1},
+			want: []string{"enter .", "enter a", "leave a", "enter b", "leave b", "leave ."},
+		},
+		{
+			name: "MinDepth",
+			opts: filepath.WalkOptions{MinDepth: 2},
+			want: []string{"enter a/1", "file a/1/x", "file a/1/y", "leave a/1", "file a/file", "file b/x"},
+		},
+	}
+	for _, tt := range tests {
+		v := &recordVisitor{root: td, ret: tt.ret}
+		err := filepath.WalkVisitor(td, tt.opts, v)
+		if err != tt.wantErr {
+			t.Errorf("%s: WalkVisitor returned %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(v.events, tt.want) {
+			t.Errorf("%s: WalkVisitor events\n got %q\nwant %q", tt.name, v.events, tt.want)
+		}
+	}
+}
+
+// failingDirFS fails every ReadDir on dir after the first one.
+type failingDirFS struct {
+	fstest.MapFS
+	dir string
+	err error
+}
+
+func (fsys failingDirFS) Open(name string) (fs.File, error) {
+	f, err := fsys.MapFS.Open(name)
+	if err != nil || name != fsys.dir {
+		return f, err
+	}
+	return &failingDir{ReadDirFile: f.(fs.ReadDirFile), err: fsys.err}, nil
+}
+
+type failingDir struct {
+	fs.ReadDirFile
+	err   error
+	reads int
+}
+
+func (d *failingDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.reads++; d.reads > 1 {
+		return nil, d.err
+	}
+	return d.ReadDirFile.ReadDir(n)
+}
+
+// With Unsorted, a read error partway through a directory is reported by
+// a second EnterDir, and LeaveDir is still called only once.
+func TestWalkVisitorReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	fsys := failingDirFS{
+		MapFS: fstest.MapFS{"d/a": {}, "d/b": {}, "d/c": {}, "e": {}},
+		dir:   "d",
+		err:   errRead,
+	}
+	opts := filepath.WalkOptions{Unsorted: true, BatchSize: 1}
+	for _, ret := range []error{nil, filepath.SkipDir} {
+		v := &recordVisitor{root: ".", ret: map[string]error{"error d": ret}}
+		if err := filepath.WalkVisitorFS(fsys, ".", opts, v); err != nil {
+			t.Errorf("WalkVisitorFS with %v: %v", ret, err)
+		}
+		want := []string{"enter .", "enter d", "file d/a", "error d", "leave d", "file e", "leave ."}
+		if !reflect.DeepEqual(v.events, want) {
+			t.Errorf("WalkVisitorFS with %v: events\n%q\nwant\n%q", ret, v.events, want)
+		}
+	}
+
+	v := &recordVisitor{root: ".", ret: map[string]error{"error d": errRead}}
+	if err := filepath.WalkVisitorFS(fsys, ".", opts, v); err != errRead {
+		t.Errorf("WalkVisitorFS = %v, want %v", err, errRead)
+	}
+	want := []string{"enter .", "enter d", "file d/a", "error d"}
+	if !reflect.DeepEqual(v.events, want) {
+		t.Errorf("WalkVisitorFS returning the error: events\n%q\nwant\n%q", v.events, want)
+	}
+}
+
+func TestWalker(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestWalker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	makeWideTree(t, td)
+
+	// skip is the path on which both Walk and the Walker skip.
+	for _, skip := range []string{"", "b", "c/2/y", td} {
+		skip := skip
+		if skip != "" && skip != td {
+			skip = filepath.Join(td, skip)
+		}
+		var want []string
+		err := filepath.Walk(td, func(path string, info os.FileInfo, err error) error {
+			want = append(want, path)
+			if err != nil {
+				return err
+			}
+			if path == skip {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		w := filepath.NewWalker(td)
+		for w.Next() {
+			got = append(got, w.Path())
+			if err := w.Err(); err != nil {
+				t.Fatalf("Walker: %s: %v", w.Path(), err)
+			}
+			if w.Entry() == nil || w.Entry().Name() != filepath.Base(w.Path()) {
+				t.Errorf("Walker: %s: Entry() = %v", w.Path(), w.Entry())
+			}
+			if w.Path() == skip {
+				w.SkipDir()
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Walker skipping %q visited\n%q\nwant\n%q", skip, got, want)
+		}
+		if w.Next() {
+			t.Errorf("Walker.Next() after the end returned true")
+		}
+	}
+
+	// Errors are reported on the path and the walk goes on.
+	w := filepath.NewWalker(filepath.Join(td, "missing"))
+	if !w.Next() || !os.IsNotExist(w.Err()) || w.Entry() != nil {
+		t.Errorf("Walker on missing root: Next, Err() = %v, Entry() = %v", w.Err(), w.Entry())
+	}
+	if w.Next() {
+		t.Errorf("Walker on missing root: second Next() returned true")
+	}
+}
+
+func TestWalkUnsorted(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestWalkUnsorted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	makeWideTree(t, td)
+	for i := 0; i < 50; i++ {
+		touch(t, filepath.Join(td, "a", fmt.Sprintf("f%02d", i)))
+	}
+	var want []string
+	if err := filepath.Walk(td, func(path string, info os.FileInfo, err error) error {
+		want = append(want, path)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, batch := range []int{0, 1, 7} {
+		opts := filepath.WalkOptions{Unsorted: true, BatchSize: batch}
+		var got []string
+		err := filepath.WalkWithOptions(td, opts, func(path string, info os.FileInfo, err error) error {
+			got = append(got, path)
+			return err
+		})
+		if err != nil {
+			t.Errorf("WalkWithOptions(%+v): %v", opts, err)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("WalkWithOptions(%+v) visited\n%q\nwant\n%q", opts, got, want)
+		}
+
+		// A file returning SkipDir skips the rest of its directory, however
+		// many batches are left.
+		got = nil
+		err = filepath.WalkWithOptions(filepath.Join(td, "a"), opts, func(path string, info os.FileInfo, err error) error {
+			got = append(got, path)
+			if err == nil && !info.IsDir() {
+				return filepath.SkipDir
+			}
+			return err
+		})
+		if err != nil {
+			t.Errorf("WalkWithOptions(%+v): %v", opts, err)
+		}
+		// Only the first file read from a is visited.
+		files := 0
+		for _, p := range got {
+			if info, err := os.Lstat(p); err == nil && !info.IsDir() && filepath.Dir(p) == filepath.Join(td, "a") {
+				files++
+			}
+		}
+		if files != 1 {
+			t.Errorf("WalkWithOptions(%+v) with SkipDir visited %d files in a, want 1: %q", opts, files, got)
+		}
+	}
+}
+
+func TestWalkSkipAll(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestWalkSkipAll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	all := makeWideTree(t, td)
+	stop := filepath.Join(td, "b", "2", "x")
+
+	// Walk visits everything up to stop and nothing after it.
+	var want []string
+	for _, path := range all {
+		want = append(want, path)
+		if path == stop {
+			break
+		}
+	}
+	var got []string
+	err = filepath.Walk(td, func(path string, info os.FileInfo, err error) error {
+		got = append(got, path)
+		if path == stop {
+			return filepath.SkipAll
+		}
+		return err
+	})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk with SkipAll = %v, visited\n%q\nwant\n%q", err, got, want)
+	}
+
+	got = nil
+	err = filepath.WalkDir(td, func(path string, d fs.DirEntry, err error) error {
+		got = append(got, path)
+		if path == stop {
+			return filepath.SkipAll
+		}
+		return err
+	})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkDir with SkipAll = %v, visited\n%q\nwant\n%q", err, got, want)
+	}
+
+	for _, opts := range []filepath.ParallelWalkOptions{
+		{Workers: 1},
+		{Workers: 4},
+		{Workers: 4, Serialize: true},
+		{Workers: 4, Ordered: true},
+	} {
+		var mu sync.Mutex
+		got = nil
+		err := filepath.ParallelWalk(td, opts, func(path string, info os.FileInfo, err error) error {
+			mu.Lock()
+			got = append(got, path)
+			mu.Unlock()
+			if path == stop {
+				return filepath.SkipAll
+			}
+			return err
+		})
+		if err != nil {
+			t.Errorf("ParallelWalk(%+v) with SkipAll: %v", opts, err)
+		}
+		if opts.Workers == 1 || opts.Ordered {
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ParallelWalk(%+v) with SkipAll visited\n%q\nwant\n%q", opts, got, want)
+			}
+		}
+	}
+}
+
+func TestWalkSameFileSystem(t *testing.T) {
+	rootInfo, err := os.Lstat("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootDev := rootInfo.Sys().(*syscall.Stat_t).Dev
+
+	// The mount points directly under "/", such as /proc.
+	want := map[string]bool{}
+	names, err := ioutil.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range names {
+		if info.IsDir() && info.Sys().(*syscall.Stat_t).Dev != rootDev {
+			want["/"+info.Name()] = true
+		}
+	}
+	if len(want) == 0 {
+		t.Skip("no mount points under /")
+	}
+
+	// MaxDepth keeps the walk cheap, mount points are never read anyway.
+	opts := filepath.WalkOptions{SameFileSystem: true, ReportMountPoints: true, MaxDepth: 2}
+	got := map[string]bool{}
+	err = filepath.WalkWithOptions("/", opts, func(path string, info os.FileInfo, err error) error {
+		if mpErr, ok := err.(*filepath.MountPointError); ok {
+			if mpErr.Path != path {
+				t.Errorf("MountPointError.Path = %q, want %q", mpErr.Path, path)
+			}
+			got[path] = true
+			return nil
+		}
+		if filepath.Dir(path) != "/" && want[filepath.Dir(path)] {
+			t.Errorf("WalkWithOptions(%+v) entered mount point: %s", opts, path)
+		}
+		// Unreadable paths under / are of no interest here.
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path := range want {
+		if !got[path] {
+			t.Errorf("WalkWithOptions(%+v) did not report mount point %s", opts, path)
+		}
+	}
+}
+
+func TestWalkFilters(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestWalkFilters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	all := makeWideTree(t, td)
+
+	defer func() {
+		*filepath.LstatP = os.Lstat
+	}()
+	tests := []struct {
+		opts filepath.WalkOptions
+		// keep reports whether Walk's path rel is expected.
+		keep func(rel string, isDir bool) bool
+		// pruned is never even looked at.
+		pruned func(rel string) bool
+	}{
+		{
+			opts: filepath.WalkOptions{Exclude: []string{"b", "2"}},
+			keep: func(rel string, isDir bool) bool {
+				return !strings.HasPrefix(rel, "b") && !strings.Contains(rel+"/", "/2/")
+			},
+			pruned: func(rel string) bool {
+				return strings.HasPrefix(rel, "b") || strings.Contains(rel+"/", "/2/")
+			},
+		},
+		{
+			opts: filepath.WalkOptions{Exclude: []string{"c/*/y", "**/z"}},
+			keep: func(rel string, isDir bool) bool {
+				return !(strings.HasPrefix(rel, "c/") && strings.HasSuffix(rel, "/y")) && !strings.HasSuffix(rel, "/z")
+			},
+		},
+		{
+			opts: filepath.WalkOptions{Include: []string{"x", "a/file"}},
+			keep: func(rel string, isDir bool) bool {
+				return isDir || strings.HasSuffix(rel, "/x") || rel == "a/file"
+			},
+		},
+		{
+			opts: filepath.WalkOptions{Include: []string{"[xy]"}, Exclude: []string{"[cd]"}},
+			keep: func(rel string, isDir bool) bool {
+				return !strings.HasPrefix(rel, "c") && !strings.HasPrefix(rel, "d") &&
+					(isDir || strings.HasSuffix(rel, "/x") || strings.HasSuffix(rel, "/y"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		var want []string
+		for _, path := range all {
+			rel, _ := filepath.Rel(td, path)
+			info, err := os.Lstat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rel == "." || tt.keep(rel, info.IsDir()) {
+				want = append(want, path)
+			}
+		}
+		*filepath.LstatP = func(path string) (os.FileInfo, error) {
+			rel, _ := filepath.Rel(td, path)
+			if tt.pruned != nil && tt.pruned(rel) {
+				t.Errorf("WalkWithOptions(%+v): lstat of excluded %q", tt.opts, rel)
+			}
+			return os.Lstat(path)
+		}
+		var got []string
+		err := filepath.WalkWithOptions(td, tt.opts, func(path string, info os.FileInfo, err error) error {
+			got = append(got, path)
+			return err
+		})
+		*filepath.LstatP = os.Lstat
+		if err != nil {
+			t.Errorf("WalkWithOptions(%+v): %v", tt.opts, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("WalkWithOptions(%+v) visited\n%q\nwant\n%q", tt.opts, got, want)
+		}
+	}
+
+	for _, opts := range []filepath.WalkOptions{{Include: []string{"["}}, {Exclude: []string{"a", "b["}}} {
+		err := filepath.WalkWithOptions(td, opts, func(path string, info os.FileInfo, err error) error {
+			t.Errorf("WalkWithOptions(%+v) visited %q", opts, path)
+			return nil
+		})
+		if err != filepath.ErrBadPattern {
+			t.Errorf("WalkWithOptions(%+v) = %v, want %v", opts, err, filepath.ErrBadPattern)
+		}
+	}
+}
+
+func TestWalkIgnoreFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestWalkIgnoreFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	files := map[string]string{
+		".gitignore":      "*.tmp\nbuild/\n",
+		"a.go":            "",
+		"a.tmp":           "",
+		"b.go":            "",
+		"build/x":         "",
+		"sub/.gitignore":  "!keep.tmp\n/local\n",
+		"sub/keep.tmp":    "",
+		"sub/x.tmp":       "",
+		"sub/local":       "",
+		"sub/deep/local":  "",
+		"other/keep.tmp":  "",
+		"other/local":     "",
+		"other/build/x.c": "",
+	}
+	for name, data := range files {
+		path := filepath.Join(td, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func() {
+		*filepath.LstatP = os.Lstat
+	}()
+	*filepath.LstatP = func(path string) (os.FileInfo, error) {
+		if rel, _ := filepath.Rel(td, path); strings.HasPrefix(rel, "build/") || strings.HasPrefix(rel, "other/build/") {
+			t.Errorf("lstat in ignored directory: %s", rel)
+		}
+		return os.Lstat(path)
+	}
+
+	ignore := filepath.NewIgnoreMatcher()
+	ignore.AddPatterns("", "b.go")
+	before := *ignore
+	opts := filepath.WalkOptions{IgnoreFile: ".gitignore", Ignore: ignore}
+	var got []string
+	err = filepath.WalkWithOptions(td, opts, func(path string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(td, path)
+		got = append(got, rel)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		".", ".gitignore", "a.go",
+		"other", "other/local",
+		"sub", "sub/.gitignore", "sub/deep", "sub/deep/local", "sub/keep.tmp",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkWithOptions(%+v) visited\n%q\nwant\n%q", opts, got, want)
+	}
+	if !reflect.DeepEqual(*ignore, before) {
+		t.Errorf("WalkWithOptions modified opts.Ignore")
+	}
+}
+
+// Include, Exclude and IgnoreFile patterns match paths relative to the
+// root as walked, even when ResolveSymlinks replaces them with their targets.
+func TestWalkFiltersResolveSymlinks(t *testing.T) {
+	testenv.MustHaveSymlink(t)
+
+	td, err := ioutil.TempDir("", "TestWalkFiltersResolveSymlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	if td, err = filepath.EvalSymlinks(td); err != nil {
+		t.Fatal(err)
+	}
+
+	real := filepath.Join(td, "real")
+	if err := os.MkdirAll(filepath.Join(real, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		".gitignore": "alias/x\n",
+		"sub/x":      "",
+		"sub/y":      "",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(real, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("sub", filepath.Join(real, "alias")); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(td, "link")
+	if err := os.Symlink("real", link); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := filepath.WalkOptions{
+		FollowSymlinks:  true,
+		ResolveSymlinks: true,
+		Exclude:         []string{"sub/x"},
+		IgnoreFile:      ".gitignore",
+	}
+	var got []string
+	err = filepath.WalkWithOptions(link, opts, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(td, path)
+		got = append(got, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkWithOptions: %v", err)
+	}
+	// real/alias resolves to real/sub: "alias/x" is ignored there, "sub/x" is excluded below.
+	want := []string{"real", "real/.gitignore", "real/sub", "real/sub/y", "real/sub", "real/sub/y"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkWithOptions visited %q, want %q", got, want)
+	}
+}
+
+// makeWideTree creates a tree of directories with several levels of fan-out
+// under dir and returns the paths Walk visits, in order.
+func makeWideTree(t *testing.T, dir string) []string {
+	for _, a := range []string{"a", "b", "c", "d"} {
+		for _, b := range []string{"1", "2", "3"} {
+			sub := filepath.Join(dir, a, b)
+			if err := os.MkdirAll(sub, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range []string{"x", "y", "z"} {
+				touch(t, filepath.Join(sub, f))
+			}
+		}
+		touch(t, filepath.Join(dir, a, "file"))
+	}
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		paths = append(paths, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestParallelWalk(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestParallelWalk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	want := makeWideTree(t, td)
+
+	for _, opts := range []filepath.ParallelWalkOptions{
+		{Workers: 1},
+		{Workers: 4},
+		{Workers: 4, Serialize: true},
+		{Workers: 4, Ordered: true},
+		{Workers: 1, Ordered: true},
+	} {
+		var mu sync.Mutex
+		var got []string
+		err := filepath.ParallelWalk(td, opts, func(path string, info os.FileInfo, err error) error {
+			mu.Lock()
+			got = append(got, path)
+			mu.Unlock()
+			return err
+		})
+		if err != nil {
+			t.Errorf("ParallelWalk(%+v): %v", opts, err)
+			continue
+		}
+		if !opts.Ordered {
+			sort.Strings(got)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParallelWalk(%+v) visited %q, want %q", opts, got, want)
+		}
+	}
+}
+
+func TestParallelWalkErrors(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestParallelWalkErrors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	makeWideTree(t, td)
+
+	errB := errors.New("b")
+	errC := errors.New("c")
+	for _, opts := range []filepath.ParallelWalkOptions{
+		{Workers: 8},
+		{Workers: 8, Ordered: true},
+	} {
+		var mu sync.Mutex
+		seen := map[string]bool{}
+		err := filepath.ParallelWalk(td, opts, func(path string, info os.FileInfo, err error) error {
+			rel, _ := filepath.Rel(td, path)
+			mu.Lock()
+			seen[rel] = true
+			mu.Unlock()
+			switch rel {
+			case "a":
+				return filepath.SkipDir
+			case "b/2/x":
+				// skips b/2/y and b/2/z
+				return filepath.SkipDir
+			case "b/3/y":
+				return errB
+			case "c/1":
+				return errC
+			}
+			return nil
+		})
+		// The lexically first error wins, whichever goroutine hits it first.
+		if err != errB {
+			t.Errorf("ParallelWalk(%+v) error = %v, want %v", opts, err, errB)
+		}
+		for _, rel := range []string{"a/1", "a/file", "b/2/y", "b/2/z", "b/3/z"} {
+			if seen[rel] {
+				t.Errorf("ParallelWalk(%+v) visited %q", opts, rel)
+			}
+		}
+		for _, rel := range []string{"b/1/z", "b/2/x", "b/3/x"} {
+			if !seen[rel] {
+				t.Errorf("ParallelWalk(%+v) did not visit %q", opts, rel)
+			}
+		}
+	}
+}
+
+// With Ordered, read-ahead stops at MaxPrefetch directories while walkFn
+// lags behind, instead of buffering the whole tree.
+func TestParallelWalkMaxPrefetch(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestParallelWalkMaxPrefetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	const dirs, files = 100, 5
+	for i := 0; i < dirs; i++ {
+		dir := filepath.Join(td, fmt.Sprintf("d%02d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < files; j++ {
+			touch(t, filepath.Join(dir, fmt.Sprintf("f%d", j)))
+		}
+	}
+	var want []string
+	if err := filepath.Walk(td, func(path string, info os.FileInfo, err error) error {
+		want = append(want, path)
+		if info.IsDir() && strings.HasSuffix(path, "5") {
+			return filepath.SkipDir
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var lstats int32
+	defer func() {
+		*filepath.LstatP = os.Lstat
+	}()
+	*filepath.LstatP = func(path string) (os.FileInfo, error) {
+		atomic.AddInt32(&lstats, 1)
+		return os.Lstat(path)
+	}
+	for _, maxPrefetch := range []int{1, 4} {
+		atomic.StoreInt32(&lstats, 0)
+		opts := filepath.ParallelWalkOptions{Workers: 4, Ordered: true, MaxPrefetch: maxPrefetch}
+		var got []string
+		err := filepath.ParallelWalk(td, opts, func(path string, info os.FileInfo, err error) error {
+			got = append(got, path)
+			if path == td {
+				// Give the workers time to run as far ahead as they may.
+				time.Sleep(50 * time.Millisecond)
+				// The root itself, its entries, and at most maxPrefetch directories.
+				if n, max := atomic.LoadInt32(&lstats), int32(1+dirs+maxPrefetch*files); n > max {
+					t.Errorf("ParallelWalk(%+v): %d lstats before the root returned, want at most %d", opts, n, max)
+				}
+			}
+			if info.IsDir() && strings.HasSuffix(path, "5") {
+				return filepath.SkipDir
+			}
+			return err
+		})
+		if err != nil {
+			t.Errorf("ParallelWalk(%+v): %v", opts, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParallelWalk(%+v) visited\n%q\nwant\n%q", opts, got, want)
+		}
+	}
+}
+
 var basetests = []PathTest{
 	{"", "."},
 	{".", "."},
@@ -937,6 +1951,374 @@ func TestEvalSymlinksIsNotExist(t *testing.T) {
 	}
 }
 
+func TestSecureJoin(t *testing.T) {
+	testenv.MustHaveSymlink(t)
+
+	root, err := ioutil.TempDir("", "TestSecureJoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(root, "etc", "passwd"))
+	for link, target := range map[string]string{
+		"abs":    "/etc",
+		"rel":    "etc",
+		"escape": "../../../../..",
+		"host":   os.TempDir(),
+		"loop":   "loop",
+		"chain":  "abs/../escape/etc/passwd",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path, want string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"etc/passwd", "etc/passwd"},
+		{"/etc/passwd", "etc/passwd"},
+		{"../../etc/passwd", "etc/passwd"},
+		{"abs/passwd", "etc/passwd"},
+		{"rel/passwd", "etc/passwd"},
+		{"escape/etc/passwd", "etc/passwd"},
+		{"escape/../../abs", "etc"},
+		{"chain", "etc/passwd"},
+		{"host", os.TempDir()},
+		{"nonexistent/x", "nonexistent/x"},
+		{"nonexistent/../abs/./passwd", "etc/passwd"},
+	}
+	for _, tt := range tests {
+		got, err := filepath.SecureJoin(root, tt.path)
+		want := filepath.Join(root, tt.want)
+		if err != nil || got != want {
+			t.Errorf("SecureJoin(root, %q) = %q, %v, want %q", tt.path, got, err, want)
+		}
+	}
+
+	if _, err := filepath.SecureJoin(root, "loop/x"); err != filepath.ErrTooManyLinks {
+		t.Errorf("SecureJoin(root, %q) error = %v, want %v", "loop/x", err, filepath.ErrTooManyLinks)
+	}
+
+	// SecureJoin goes through the same lstat hook as EvalSymlinks.
+	defer func() {
+		*filepath.LstatP = os.Lstat
+	}()
+	errLstat := errors.New("lstat failed")
+	*filepath.LstatP = func(path string) (os.FileInfo, error) {
+		if path == filepath.Join(root, "etc") {
+			return nil, errLstat
+		}
+		return os.Lstat(path)
+	}
+	if _, err := filepath.SecureJoin(root, "etc/passwd"); err != errLstat {
+		t.Errorf("SecureJoin(root, %q) with failing lstat: error = %v, want %v", "etc/passwd", err, errLstat)
+	}
+}
+
+func TestEvalSymlinksTrace(t *testing.T) {
+	testenv.MustHaveSymlink(t)
+
+	tmpDir, err := ioutil.TempDir("", "TestEvalSymlinksTrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if tmpDir, err = filepath.EvalSymlinks(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(tmpDir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(tmpDir, "file"))
+	for link, target := range map[string]string{
+		"l1":      "l2",
+		"l2":      "real",
+		"abs":     filepath.Join(tmpDir, "real"),
+		"loop":    "loop",
+		"missing": "nonexistent",
+	} {
+		if err := os.Symlink(target, filepath.Join(tmpDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest, steps, err := filepath.EvalSymlinksTrace(filepath.Join(tmpDir, "l1"))
+	want := []filepath.SymlinkStep{
+		{Link: filepath.Join(tmpDir, "l1"), Target: "l2", Dest: tmpDir},
+		{Link: filepath.Join(tmpDir, "l2"), Target: "real", Dest: tmpDir},
+	}
+	if err != nil || dest != filepath.Join(tmpDir, "real") || !reflect.DeepEqual(steps, want) {
+		t.Errorf("EvalSymlinksTrace(l1) = %q, %+v, %v, want %q, %+v", dest, steps, err, filepath.Join(tmpDir, "real"), want)
+	}
+
+	_, steps, err = filepath.EvalSymlinksTrace(filepath.Join(tmpDir, "abs"))
+	want = []filepath.SymlinkStep{{Link: filepath.Join(tmpDir, "abs"), Target: filepath.Join(tmpDir, "real"), Dest: "/"}}
+	if err != nil || !reflect.DeepEqual(steps, want) {
+		t.Errorf("EvalSymlinksTrace(abs) steps = %+v, %v, want %+v", steps, err, want)
+	}
+
+	tests := []struct {
+		path     string
+		failed   string
+		err      error
+		numSteps int
+	}{
+		{"loop", "loop", filepath.ErrTooManyLinks, 255},
+		{"file/x", "file", syscall.ENOTDIR, 0},
+		{"missing/x", "nonexistent", nil, 1},
+	}
+	for _, tt := range tests {
+		_, steps, err := filepath.EvalSymlinksTrace(filepath.Join(tmpDir, tt.path))
+		symErr, ok := err.(*filepath.SymlinkError)
+		if !ok {
+			t.Errorf("EvalSymlinksTrace(%s) error = %v, want *SymlinkError", tt.path, err)
+			continue
+		}
+		if symErr.Path != filepath.Join(tmpDir, tt.failed) {
+			t.Errorf("EvalSymlinksTrace(%s) failed at %q, want %q", tt.path, symErr.Path, filepath.Join(tmpDir, tt.failed))
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) || tt.err == nil && !os.IsNotExist(symErr.Err) {
+			t.Errorf("EvalSymlinksTrace(%s) error = %v", tt.path, err)
+		}
+		if len(steps) != tt.numSteps {
+			t.Errorf("EvalSymlinksTrace(%s) returned %d steps, want %d", tt.path, len(steps), tt.numSteps)
+		}
+
+		// EvalSymlinks keeps returning the bare error.
+		if _, err := filepath.EvalSymlinks(filepath.Join(tmpDir, tt.path)); !reflect.DeepEqual(err, symErr.Err) {
+			t.Errorf("EvalSymlinks(%s) error = %v, want %v", tt.path, err, symErr.Err)
+		}
+	}
+}
+
+func TestEvalSymlinksMode(t *testing.T) {
+	testenv.MustHaveSymlink(t)
+
+	tmpDir, err := ioutil.TempDir("", "TestEvalSymlinksMode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if tmpDir, err = filepath.EvalSymlinks(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(tmpDir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(tmpDir, "file"))
+	for link, target := range map[string]string{
+		"link":     "real",
+		"dangling": "nowhere/x",
+	} {
+		if err := os.Symlink(target, filepath.Join(tmpDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path string
+		// want is the ResolveMissing result; ResolveExisting agrees when
+		// exists is set and fails otherwise.
+		want   string
+		exists bool
+	}{
+		{"link", "real", true},
+		{"link/../file", "file", true},
+		{"link/out/../out.txt", "real/out.txt", false},
+		{"missing/../link", "real", false},
+		{"file/x/..", "file", false},
+		{"dangling", "nowhere/x", false},
+		{"dangling/../../link/y", "real/y", false},
+	}
+	for _, tt := range tests {
+		// Join would clean the ".." away.
+		path := simpleJoin(tmpDir, tt.path)
+		want := filepath.Join(tmpDir, tt.want)
+		if got, err := filepath.EvalSymlinksMode(path, filepath.ResolveMissing); err != nil || got != want {
+			t.Errorf("EvalSymlinksMode(%s, ResolveMissing) = %q, %v, want %q", tt.path, got, err, want)
+		}
+		got, err := filepath.EvalSymlinksMode(path, filepath.ResolveExisting)
+		switch {
+		case tt.exists && (err != nil || got != want):
+			t.Errorf("EvalSymlinksMode(%s, ResolveExisting) = %q, %v, want %q", tt.path, got, err, want)
+		case !tt.exists && err == nil:
+			t.Errorf("EvalSymlinksMode(%s, ResolveExisting) = %q, want an error", tt.path, got)
+		}
+	}
+}
+
+func TestEvalSymlinksWithOptions(t *testing.T) {
+	testenv.MustHaveSymlink(t)
+
+	tmpDir, err := ioutil.TempDir("", "TestEvalSymlinksWithOptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if tmpDir, err = filepath.EvalSymlinks(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []string{"real", "d"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"a":    "b",
+		"b":    "a",
+		"self": "self",
+		"grow": "grow/x",
+		"d/up": "..",
+		"l10":  "real",
+	}
+	// l0 -> l1 -> ... -> l10 -> real
+	for i := 0; i < 10; i++ {
+		links[fmt.Sprintf("l%d", i)] = fmt.Sprintf("l%d", i+1)
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(tmpDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	join := func(names ...string) []string {
+		for i, name := range names {
+			names[i] = filepath.Join(tmpDir, name)
+		}
+		return names
+	}
+	tests := []struct {
+		path  string
+		opts  filepath.EvalSymlinksOptions
+		want  string
+		err   error
+		cycle []string
+	}{
+		{path: "l0", want: "real"},
+		{path: "l0", opts: filepath.EvalSymlinksOptions{MaxLinks: 11}, want: "real"},
+		{path: "l0", opts: filepath.EvalSymlinksOptions{MaxLinks: 10}, err: filepath.ErrTooManyLinks},
+		{path: "l0/missing", opts: filepath.EvalSymlinksOptions{Mode: filepath.ResolveMissing}, want: "real/missing"},
+		{path: "d/up/d/up/d/up/real", want: "real"},
+		{path: "a", cycle: join("a", "b", "a")},
+		{path: "l9/../b", cycle: join("b", "a", "b")},
+		{path: "self/x", cycle: join("self", "self")},
+		{path: "grow", opts: filepath.EvalSymlinksOptions{MaxLinks: 20}, err: filepath.ErrTooManyLinks},
+	}
+	for _, tt := range tests {
+		got, err := filepath.EvalSymlinksWithOptions(filepath.Join(tmpDir, tt.path), tt.opts)
+		switch {
+		case tt.cycle != nil:
+			cycleErr, ok := err.(*filepath.SymlinkCycleError)
+			if !ok || !reflect.DeepEqual(cycleErr.Cycle, tt.cycle) {
+				t.Errorf("EvalSymlinksWithOptions(%s, %+v) = %q, %v, want cycle %q", tt.path, tt.opts, got, err, tt.cycle)
+			}
+		case tt.err != nil:
+			if err != tt.err {
+				t.Errorf("EvalSymlinksWithOptions(%s, %+v) = %q, %v, want %v", tt.path, tt.opts, got, err, tt.err)
+			}
+		default:
+			if want := filepath.Join(tmpDir, tt.want); err != nil || got != want {
+				t.Errorf("EvalSymlinksWithOptions(%s, %+v) = %q, %v, want %q", tt.path, tt.opts, got, err, want)
+			}
+		}
+	}
+}
+
+// mapSymlinkFS is an in-memory SymlinkFS. Entries with fs.ModeSymlink hold
+// the link target in Data, parents of entries are directories.
+type mapSymlinkFS fstest.MapFS
+
+func (m mapSymlinkFS) name(path string) string {
+	if path = strings.TrimPrefix(path, "/"); path == "" {
+		return "."
+	}
+	return path
+}
+
+// Lstat does not use fs.Stat, as newer MapFS versions follow symlinks.
+func (m mapSymlinkFS) Lstat(path string) (os.FileInfo, error) {
+	name := m.name(path)
+	if f, ok := m[name]; ok {
+		return mapFileInfo{name: filepath.Base(name), mode: f.Mode}, nil
+	}
+	for file := range m {
+		if name == "." || strings.HasPrefix(file, name+"/") {
+			return mapFileInfo{name: filepath.Base(name), mode: fs.ModeDir}, nil
+		}
+	}
+	return nil, &os.PathError{Op: "lstat", Path: path, Err: fs.ErrNotExist}
+}
+
+type mapFileInfo struct {
+	name string
+	mode fs.FileMode
+}
+
+func (fi mapFileInfo) Name() string       { return fi.name }
+func (fi mapFileInfo) Size() int64        { return 0 }
+func (fi mapFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi mapFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi mapFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi mapFileInfo) Sys() interface{}   { return nil }
+
+func (m mapSymlinkFS) Readlink(path string) (string, error) {
+	f, ok := m[m.name(path)]
+	if !ok || f.Mode&fs.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: syscall.EINVAL}
+	}
+	return string(f.Data), nil
+}
+
+func TestEvalSymlinksFS(t *testing.T) {
+	link := func(target string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink}
+	}
+	// The topology of TestIssue13582, plus a few more.
+	fsys := mapSymlinkFS{
+		"tmp/dir/file":     {},
+		"tmp/link_to_dir":  link("/tmp/dir"),
+		"tmp/dir/link1":    link("/tmp/link_to_dir/file"),
+		"tmp/dir/link2":    link("/tmp/link_to_dir/link1"),
+		"tmp/dir/rel":      link("../link_to_dir/./file"),
+		"tmp/loop":         link("loop"),
+		"tmp/dir/dangling": link("nonexistent"),
+	}
+	tests := []struct {
+		path, want string
+	}{
+		{"/tmp/dir", "/tmp/dir"},
+		{"/tmp/link_to_dir", "/tmp/dir"},
+		{"/tmp/link_to_dir/file", "/tmp/dir/file"},
+		{"/tmp/link_to_dir/link1", "/tmp/dir/file"},
+		{"/tmp/link_to_dir/link2", "/tmp/dir/file"},
+		{"/tmp/link_to_dir/rel", "/tmp/dir/file"},
+		{"/tmp/../../../tmp/link_to_dir/file", "/tmp/dir/file"},
+		{"tmp/link_to_dir/link2", "/tmp/dir/file"},
+	}
+	for _, tt := range tests {
+		if got, err := filepath.EvalSymlinksFS(fsys, tt.path); err != nil || got != tt.want {
+			t.Errorf("EvalSymlinksFS(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+
+	if _, err := filepath.EvalSymlinksFS(fsys, "/tmp/loop"); err != filepath.ErrTooManyLinks {
+		t.Errorf("EvalSymlinksFS(%q) error = %v, want %v", "/tmp/loop", err, filepath.ErrTooManyLinks)
+	}
+	if _, err := filepath.EvalSymlinksFS(fsys, "/tmp/dir/dangling"); !os.IsNotExist(err) {
+		t.Errorf("EvalSymlinksFS(%q) error = %v, want not exist", "/tmp/dir/dangling", err)
+	}
+}
+
 func TestIssue13582(t *testing.T) {
 	testenv.MustHaveSymlink(t)
 
@@ -1174,6 +2556,9 @@ var reltests = []RelTests{
 	{"/../../a/b", "/../../a/b/c/d", "c/d"},
 	{".", "a/b", "a/b"},
 	{".", "..", ".."},
+	{"a/b", ".", "../.."},
+	{"a", ".", ".."},
+	{"a", "", ".."},
 
 	// can't do purely lexically
 	{"..", ".", "err"},
@@ -1371,6 +2756,153 @@ func TestWalkSymlink(t *testing.T) {
 	testWalkSymlink(t, os.Symlink)
 }
 
+func TestWalkFollowSymlinks(t *testing.T) {
+	testenv.MustHaveSymlink(t)
+
+	tmpDir, err := ioutil.TempDir("", "TestWalkFollowSymlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(tmpDir, "root")
+	for _, dir := range []string{"root/a", "real"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch(t, filepath.Join(tmpDir, "root/a/file"))
+	touch(t, filepath.Join(tmpDir, "real/f"))
+	for link, target := range map[string]string{
+		"root/link":     "../real",
+		"root/loop":     ".",
+		"root/a/up":     "..",
+		"root/dangling": "nonexistent",
+	} {
+		if err := os.Symlink(target, filepath.Join(tmpDir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walk := func(opts filepath.WalkOptions) map[string]error {
+		visited := map[string]error{}
+		err := filepath.WalkWithOptions(root, opts, func(path string, info os.FileInfo, err error) error {
+			rel, _ := filepath.Rel(tmpDir, path)
+			visited[rel] = err
+			if loop, ok := err.(*filepath.SymlinkLoopError); ok {
+				if loop.Ancestor != root {
+					t.Errorf("loop %q: ancestor %q, want %q", loop.Path, loop.Ancestor, root)
+				}
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			t.Errorf("WalkWithOptions(%+v): %v", opts, err)
+		}
+		return visited
+	}
+
+	visited := walk(filepath.WalkOptions{FollowSymlinks: true})
+	for _, rel := range []string{"root", "root/a", "root/a/file", "root/link", "root/link/f", "root/dangling"} {
+		if err, ok := visited[rel]; !ok || err != nil {
+			t.Errorf("FollowSymlinks: %q visited=%v err=%v", rel, ok, err)
+		}
+	}
+	for _, rel := range []string{"root/loop", "root/a/up"} {
+		if _, ok := visited[rel].(*filepath.SymlinkLoopError); !ok {
+			t.Errorf("FollowSymlinks: %q reported %v, want *SymlinkLoopError", rel, visited[rel])
+		}
+	}
+	if len(visited) != 8 {
+		t.Errorf("FollowSymlinks visited %v", visited)
+	}
+
+	visited = walk(filepath.WalkOptions{FollowSymlinks: true, ResolveSymlinks: true})
+	for _, rel := range []string{"real", "real/f"} {
+		if _, ok := visited[rel]; !ok {
+			t.Errorf("ResolveSymlinks: %q not visited: %v", rel, visited)
+		}
+	}
+	if _, ok := visited["root/link/f"]; ok {
+		t.Errorf("ResolveSymlinks: visited link path root/link/f")
+	}
+}
+
+func TestWalkDepth(t *testing.T) {
+	td, err := ioutil.TempDir("", "TestWalkDepth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	makeWideTree(t, td)
+
+	defer func() {
+		*filepath.LstatP = os.Lstat
+	}()
+	tests := []struct {
+		opts     filepath.WalkOptions
+		want     []string
+		maxDepth int
+	}{
+		{filepath.WalkOptions{MaxDepth: 1}, []string{".", "a", "b", "c", "d"}, 1},
+		// -maxdepth 0: the root only.
+		{filepath.WalkOptions{MaxDepth: 0, HasMaxDepth: true}, []string{"."}, 0},
+		{filepath.WalkOptions{MaxDepth: 1, HasMaxDepth: true}, []string{".", "a", "b", "c", "d"}, 1},
+		{filepath.WalkOptions{MinDepth: 3}, nil, 3},
+		{filepath.WalkOptions{MinDepth: 1, MaxDepth: 2}, []string{
+			"a", "a/1", "a/2", "a/3", "a/file",
+			"b", "b/1", "b/2", "b/3", "b/file",
+			"c", "c/1", "c/2", "c/3", "c/file",
+			"d", "d/1", "d/2", "d/3", "d/file",
+		}, 2},
+		{filepath.WalkOptions{MinDepth: 2, MaxDepth: 2}, []string{
+			"a/1", "a/2", "a/3", "a/file",
+			"b/1", "b/2", "b/3", "b/file",
+			"c/1", "c/2", "c/3", "c/file",
+			"d/1", "d/2", "d/3", "d/file",
+		}, 2},
+	}
+	for _, tt := range tests {
+		// Nothing deeper than maxDepth may even be looked at.
+		*filepath.LstatP = func(path string) (os.FileInfo, error) {
+			rel, _ := filepath.Rel(td, path)
+			if depth := strings.Count(rel, "/") + 1; rel != "." && depth > tt.maxDepth {
+				t.Errorf("WalkWithOptions(%+v): lstat %q at depth %d", tt.opts, rel, depth)
+			}
+			return os.Lstat(path)
+		}
+		var got []string
+		err := filepath.WalkWithOptions(td, tt.opts, func(path string, info os.FileInfo, err error) error {
+			rel, _ := filepath.Rel(td, path)
+			got = append(got, rel)
+			return err
+		})
+		if err != nil {
+			t.Errorf("WalkWithOptions(%+v): %v", tt.opts, err)
+		}
+		if tt.want == nil {
+			// MinDepth only: everything at depth 3.
+			for _, rel := range got {
+				if strings.Count(rel, "/") != 2 {
+					t.Errorf("WalkWithOptions(%+v) visited %q", tt.opts, rel)
+				}
+			}
+			if len(got) != 36 {
+				t.Errorf("WalkWithOptions(%+v) visited %d paths, want 36", tt.opts, len(got))
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("WalkWithOptions(%+v) visited %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
 func TestIssue29372(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "TestIssue29372")
 	if err != nil {
@@ -0,0 +1,308 @@
+package filepath
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pattern 预先解析的pattern，语法与Match相同
+//
+// Match每次调用都需要通过scanChunk、getEcs重新解析pattern，在同一个pattern匹配大量name时，解析的开销占主导。
+// Pattern只在Compile时解析一次，之后的Match不再解析，也不会再出现错误。
+// Pattern创建之后不会被修改，可以被多个goroutine并发使用
+type Pattern struct {
+	// pattern原始数据
+	pattern string
+	// 展开'{}'之后的各个选项，任意一个匹配即可
+	alternatives []compiledAlternative
+}
+
+// compiledAlternative 展开'{}'之后的一个pattern
+type compiledAlternative struct {
+	// pattern不包含"**" subPath时使用，整体匹配name
+	chunks []compiledChunk
+	// pattern包含"**" subPath时使用，以subPath为单位匹配
+	segments      []compiledSegment
+	hasDoubleStar bool
+}
+
+// compiledSegment 包含"**"的pattern中的一个subPattern
+type compiledSegment struct {
+	doubleStar bool
+	chunks     []compiledChunk
+}
+
+// compiledChunk 对应scanChunk得到的一个chunk
+type compiledChunk struct {
+	startWithStar bool
+	items         []chunkItem
+}
+
+// chunkItem的种类
+const (
+	// 字面量，转义符已经去除
+	itemLiteral = iota
+	// '?'
+	itemAny
+	// "[]"
+	itemRange
+)
+
+// chunkItem chunk中最小的匹配单位
+type chunkItem struct {
+	kind    int
+	literal string
+	negated bool
+	ranges  []runeRange
+}
+
+// runeRange "[]"中的一个范围，枚举的字符lo == hi
+type runeRange struct {
+	lo, hi rune
+}
+
+// Compile 解析pattern，如果pattern存在语法错误，返回ErrBadPattern
+//
+// 与Match不同，Compile会检查pattern的每一个部分。
+// Match只有在匹配到存在问题的部分时才会报告错误，比如Match("a[", "b")返回false, nil
+func Compile(pattern string) (*Pattern, error) {
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pattern{pattern: pattern}
+	for _, s := range patterns {
+		var alt compiledAlternative
+		if hasDoubleStar(s) {
+			alt.hasDoubleStar = true
+			for _, seg := range splitSegments(s) {
+				if seg == doubleStar {
+					alt.segments = append(alt.segments, compiledSegment{doubleStar: true})
+					continue
+				}
+				chunks, err := compileChunks(seg)
+				if err != nil {
+					return nil, err
+				}
+				alt.segments = append(alt.segments, compiledSegment{chunks: chunks})
+			}
+		} else if alt.chunks, err = compileChunks(s); err != nil {
+			return nil, err
+		}
+		p.alternatives = append(p.alternatives, alt)
+	}
+	return p, nil
+}
+
+// MustCompile 与Compile相同，但是pattern存在语法错误时panic
+// 用于初始化全局变量
+func MustCompile(pattern string) *Pattern {
+	p, err := Compile(pattern)
+	if err != nil {
+		panic("filepath: Compile(" + strconv.Quote(pattern) + "): " + err.Error())
+	}
+	return p
+}
+
+// String 返回Compile时的pattern
+func (p *Pattern) String() string {
+	return p.pattern
+}
+
+// Match 检测name是否匹配pattern，语义与Match相同
+func (p *Pattern) Match(name string) bool {
+	for i := range p.alternatives {
+		alt := &p.alternatives[i]
+		if alt.hasDoubleStar {
+			if matchCompiledSegments(alt.segments, splitSegments(name)) {
+				return true
+			}
+		} else if matchCompiledChunks(alt.chunks, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileChunks 以scanChunk分割pattern，并解析各个chunk
+func compileChunks(pattern string) ([]compiledChunk, error) {
+	var chunks []compiledChunk
+	for len(pattern) > 0 {
+		var c compiledChunk
+		var chunk string
+		c.startWithStar, chunk, pattern = scanChunk(pattern)
+		items, err := compileItems(chunk)
+		if err != nil {
+			return nil, err
+		}
+		c.items = items
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
+
+// compileItems 解析chunk，解析规则与matchChunk相同
+func compileItems(chunk string) ([]chunkItem, error) {
+	var items []chunkItem
+	// 连续的字面量合并为一个item
+	var literal []byte
+	flush := func() {
+		if len(literal) > 0 {
+			items = append(items, chunkItem{kind: itemLiteral, literal: string(literal)})
+			literal = nil
+		}
+	}
+	for len(chunk) > 0 {
+		switch chunk[0] {
+		case '[':
+			flush()
+			chunk = chunk[1:]
+			if len(chunk) == 0 {
+				return nil, ErrBadPattern
+			}
+			item := chunkItem{kind: itemRange}
+			item.negated = chunk[0] == '^'
+			if item.negated {
+				chunk = chunk[1:]
+			}
+			for {
+				if len(chunk) > 0 && chunk[0] == ']' && len(item.ranges) > 0 {
+					chunk = chunk[1:]
+					break
+				}
+				var lo, hi rune
+				var err error
+				if lo, chunk, err = getEcs(chunk); err != nil {
+					return nil, err
+				}
+				hi = lo
+				if chunk[0] == '-' {
+					if hi, chunk, err = getEcs(chunk[1:]); err != nil {
+						return nil, err
+					}
+				}
+				item.ranges = append(item.ranges, runeRange{lo: lo, hi: hi})
+			}
+			items = append(items, item)
+		case '?':
+			flush()
+			items = append(items, chunkItem{kind: itemAny})
+			chunk = chunk[1:]
+		case '\\':
+			chunk = chunk[1:]
+			if len(chunk) == 0 {
+				return nil, ErrBadPattern
+			}
+			fallthrough
+		default:
+			literal = append(literal, chunk[0])
+			chunk = chunk[1:]
+		}
+	}
+	flush()
+	return items, nil
+}
+
+// matchCompiledChunks 与match相同的匹配逻辑，不会出现错误
+func matchCompiledChunks(chunks []compiledChunk, name string) bool {
+Chunks:
+	for i := range chunks {
+		c := &chunks[i]
+		// 说明pattern只剩下'*'来匹配剩余的name
+		if c.startWithStar && len(c.items) == 0 {
+			return !strings.Contains(name, string(os.PathSeparator))
+		}
+		if !c.startWithStar {
+			restName, ok := matchItems(c.items, name)
+			if !ok {
+				return false
+			}
+			name = restName
+			continue
+		}
+		// 以'*'起始，非贪婪匹配
+		for j := 0; j < len(name); j++ {
+			if j-1 >= 0 && os.IsPathSeparator(name[j-1]) {
+				break
+			}
+			restName, ok := matchItems(c.items, name[j:])
+			if ok {
+				// chunk是最后一个，且name仍有剩余. 则需要继续match检测
+				if i == len(chunks)-1 && len(restName) > 0 {
+					continue
+				}
+				name = restName
+				continue Chunks
+			}
+		}
+		return false
+	}
+	return len(name) == 0
+}
+
+// matchItems 检测items是否匹配s的起始部分，与matchChunk相同
+func matchItems(items []chunkItem, s string) (rest string, matched bool) {
+	for i := range items {
+		item := &items[i]
+		switch item.kind {
+		case itemLiteral:
+			if !strings.HasPrefix(s, item.literal) {
+				return
+			}
+			s = s[len(item.literal):]
+		case itemAny:
+			if len(s) == 0 || s[0] == os.PathSeparator {
+				return
+			}
+			_, n := utf8.DecodeRuneInString(s)
+			s = s[n:]
+		case itemRange:
+			if len(s) == 0 {
+				return
+			}
+			r, n := utf8.DecodeRuneInString(s)
+			s = s[n:]
+			match := false
+			for _, rr := range item.ranges {
+				if rr.lo <= r && r <= rr.hi {
+					match = true
+					break
+				}
+			}
+			if match == item.negated {
+				return
+			}
+		}
+	}
+	return s, true
+}
+
+// matchCompiledSegments 与matchDoubleStar相同的匹配逻辑
+func matchCompiledSegments(segments []compiledSegment, names []string) bool {
+	for len(segments) > 0 {
+		if !segments[0].doubleStar {
+			if len(names) == 0 || !matchCompiledChunks(segments[0].chunks, names[0]) {
+				return false
+			}
+			segments, names = segments[1:], names[1:]
+			continue
+		}
+		// 去除多余的连续重复的"**"
+		for len(segments) > 0 && segments[0].doubleStar {
+			segments = segments[1:]
+		}
+		// "**"是最后一个subPattern，匹配剩余所有的subPath
+		if len(segments) == 0 {
+			return true
+		}
+		for i := 0; i <= len(names); i++ {
+			if matchCompiledSegments(segments, names[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(names) == 0
+}
@@ -0,0 +1,62 @@
+package filepath
+
+import (
+	"strings"
+)
+
+// SplitPattern 将pattern分为最长的不包含魔法字符的目录前缀dir，以及剩余的pattern rest
+//
+// dir中的转义已经去除，是一个可以直接访问的路径，匹配rest时只需要从dir开始读取目录。
+// pattern的第一个subPattern就包含魔法字符时，dir为""，表示从当前目录开始。例如：
+//
+//	"var/log/app-*/2024/*.gz" -> "var/log", "app-*/2024/*.gz"
+//	"/*/bin"                  -> "/", "*/bin"
+//	"a/b"                     -> "a", "b"
+//	"*.go"                    -> "", "*.go"
+//
+// rest总是包含pattern的最后一个subPattern。'{}'视为魔法字符，pattern不需要预先展开
+func SplitPattern(pattern string) (dir, rest string) {
+	// dir为pattern[:end]
+	end := -1
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != Separator {
+			continue
+		}
+		if _, ok := unescapeLiteral(pattern[end+1 : i]); !ok {
+			break
+		}
+		end = i
+	}
+	if end < 0 {
+		return "", pattern
+	}
+	dir, _ = unescapeLiteral(pattern[:end])
+	if dir == "" {
+		dir = string(Separator)
+	}
+	return dir, pattern[end+1:]
+}
+
+// unescapeLiteral pattern不包含魔法字符时，返回去除转义之后的字面量
+// 末尾不完整的转义由Match报告错误，视为包含魔法字符
+func unescapeLiteral(pattern string) (string, bool) {
+	if !strings.ContainsAny(pattern, `*?[{}\`) {
+		return pattern, true
+	}
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*', '?', '[', '{', '}':
+			return "", false
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return "", false
+			}
+			b.WriteByte(pattern[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), true
+}
@@ -0,0 +1,169 @@
+package filepath
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 匹配一个subPath中任意数量字符的'*'
+const regexpStar = `[^/]*`
+
+// RegexpString 将pattern转换为等价的正则表达式，语法与regexp/syntax相同。
+// 无法等价的一种情况见(*Pattern).RegexpString
+//
+// 返回的正则表达式以'^'、'$'锚定，匹配整个name：
+// 1. '*'、'?'不匹配Separator，与Match相同
+// 2. "[^...]"以及转义保持原样的语义
+// 3. '{a,b}'展开为"(?:a|b)"，"**" subPath匹配任意层目录
+//
+// pattern存在语法错误时返回ErrBadPattern
+func RegexpString(pattern string) (string, error) {
+	p, err := Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return p.RegexpString(), nil
+}
+
+// RegexpString 返回与p等价的正则表达式，见RegexpString
+//
+// 有一种情况无法等价：不包含"**"的pattern中，'*'之后的chunk可以匹配'/'(比如"[^a]")，且不是最后一个chunk。
+// Match对这样的chunk取'*'之后第一个匹配的位置，不再回溯，而正则表达式会回溯，两者的结果可能不同。
+// 比如"*[^a]*"，Match("*[^a]*", "ba/,")为false，而正则表达式匹配"ba/,"
+func (p *Pattern) RegexpString() string {
+	var b strings.Builder
+	b.WriteString("^")
+	if len(p.alternatives) > 1 {
+		b.WriteString("(?:")
+	}
+	for i := range p.alternatives {
+		if i > 0 {
+			b.WriteString("|")
+		}
+		alt := &p.alternatives[i]
+		if alt.hasDoubleStar {
+			writeSegmentsRegexp(&b, alt.segments)
+		} else {
+			writeChunksRegexp(&b, alt.chunks, false)
+		}
+	}
+	if len(p.alternatives) > 1 {
+		b.WriteString(")")
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// writeSegmentsRegexp 转换包含"**"的pattern，与matchCompiledSegments的语义相同
+//
+// 除第一个subPattern之外，每个subPattern都以'/'开头，"**"匹配0个或者多个"/subPath"。
+// "**"是第一个subPattern时匹配0个或者多个"subPath/"，此时下一个subPattern不再以'/'开头
+func writeSegmentsRegexp(b *strings.Builder, segments []compiledSegment) {
+	first := true
+	for i := 0; i < len(segments); i++ {
+		if !segments[i].doubleStar {
+			if !first {
+				b.WriteString("/")
+			}
+			writeChunksRegexp(b, segments[i].chunks, true)
+			first = false
+			continue
+		}
+		// 去除多余的连续重复的"**"
+		for i+1 < len(segments) && segments[i+1].doubleStar {
+			i++
+		}
+		switch {
+		case first && i == len(segments)-1:
+			// 只有"**"，匹配任意name
+			b.WriteString("(?s:.*)")
+		case first:
+			b.WriteString("(?:" + regexpStar + "/)*")
+		default:
+			b.WriteString("(?:/" + regexpStar + ")*")
+		}
+	}
+}
+
+// writeChunksRegexp 转换不包含"**"的pattern，与matchCompiledChunks的语义相同
+//
+// inSegment为true时，chunks匹配的是splitSegments得到的一个subPath，其中不包含'/'，"[]"也不能匹配'/'
+func writeChunksRegexp(b *strings.Builder, chunks []compiledChunk, inSegment bool) {
+	for i := range chunks {
+		c := &chunks[i]
+		if c.startWithStar {
+			b.WriteString(regexpStar)
+		}
+		for j := range c.items {
+			item := &c.items[j]
+			switch item.kind {
+			case itemLiteral:
+				b.WriteString(regexp.QuoteMeta(item.literal))
+			case itemAny:
+				b.WriteString(`[^/]`)
+			case itemRange:
+				writeRangeRegexp(b, item, inSegment)
+			}
+		}
+	}
+}
+
+// writeRangeRegexp 转换"[]"，noSep为true时从中去除'/'
+//
+// 与Match相同，lo > hi的范围不匹配任何字符，直接丢弃，否则regexp无法编译
+func writeRangeRegexp(b *strings.Builder, item *chunkItem, noSep bool) {
+	ranges := make([]runeRange, 0, len(item.ranges)+1)
+	for _, rr := range item.ranges {
+		if rr.lo > rr.hi {
+			continue
+		}
+		if !noSep || item.negated || rr.lo > Separator || rr.hi < Separator {
+			ranges = append(ranges, rr)
+			continue
+		}
+		// 以'/'为界拆分为两个范围
+		if rr.lo < Separator {
+			ranges = append(ranges, runeRange{lo: rr.lo, hi: Separator - 1})
+		}
+		if rr.hi > Separator {
+			ranges = append(ranges, runeRange{lo: Separator + 1, hi: rr.hi})
+		}
+	}
+
+	switch {
+	case len(ranges) == 0 && !item.negated:
+		// 没有可以匹配的字符，比如"[b-a]"以及noSep时的"[/]"
+		b.WriteString(`[^\x00-\x{10ffff}]`)
+		return
+	case len(ranges) == 0 && !noSep:
+		// 取反之后匹配任意字符，包括'/'
+		b.WriteString(`[\x00-\x{10ffff}]`)
+		return
+	}
+
+	b.WriteString("[")
+	if item.negated {
+		b.WriteString("^")
+		if noSep {
+			b.WriteString(quoteClassRune(Separator))
+		}
+	}
+	for _, rr := range ranges {
+		b.WriteString(quoteClassRune(rr.lo))
+		if rr.hi != rr.lo {
+			b.WriteString("-")
+			b.WriteString(quoteClassRune(rr.hi))
+		}
+	}
+	b.WriteString("]")
+}
+
+// quoteClassRune 转义"[]"中的字符，除字母、数字以外的字符都以\x{...}表示
+func quoteClassRune(r rune) string {
+	if r < utf8.RuneSelf && ('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+		return string(r)
+	}
+	return `\x{` + strconv.FormatInt(int64(r), 16) + `}`
+}
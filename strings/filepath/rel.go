@@ -0,0 +1,85 @@
+package filepath
+
+import (
+	"errors"
+	"strings"
+)
+
+// Rel 返回targpath相对于basepath的相对路径，即Join(basepath, Rel(basepath, targpath)) == Clean(targpath)
+//
+// Rel是纯词法处理，不访问文件系统。basepath和targpath会先经过Clean处理
+// 以下情况无法得到相对路径，返回error:
+// 1. basepath和targpath一个是绝对路径、一个是相对路径
+// 2. basepath在与targpath的公共前缀之后仍剩余'..'，此时需要知道当前工作目录才能确定
+func Rel(basepath, targpath string) (string, error) {
+	base := Clean(basepath)
+	targ := Clean(targpath)
+	if targ == base {
+		return ".", nil
+	}
+	// "."代表当前目录，等价于空的相对路径前缀
+	if base == "." {
+		base = ""
+	} else if targ == "." {
+		targ = ""
+	}
+	// 不能使用IsAbs, 因为base可能已经被置为""
+	baseSlashed := len(base) > 0 && base[0] == Separator
+	targSlashed := len(targ) > 0 && targ[0] == Separator
+	if baseSlashed != targSlashed {
+		return "", errors.New("Rel: can't make " + targpath + " relative to " + basepath)
+	}
+
+	// 逐个subPath比较，找到第一个不相同的subPath。
+	// base[b0:bi]和targ[t0:ti]分别指向当前比较的subPath
+	bl := len(base)
+	tl := len(targ)
+	var b0, bi, t0, ti int
+	for {
+		for bi < bl && base[bi] != Separator {
+			bi++
+		}
+		for ti < tl && targ[ti] != Separator {
+			ti++
+		}
+		if targ[t0:ti] != base[b0:bi] {
+			break
+		}
+		// 跳过分隔符
+		if bi < bl {
+			bi++
+		}
+		if ti < tl {
+			ti++
+		}
+		b0 = bi
+		t0 = ti
+	}
+	// Clean之后'..'只可能出现在相对路径的开头。base剩余'..'说明需要知道父目录的名字，纯词法无法处理
+	if base[b0:bi] == ".." {
+		return "", errors.New("Rel: can't make " + targpath + " relative to " + basepath)
+	}
+	// base仍有剩余的subPath，每个subPath都需要一个'..'回退，之后再拼接targ剩余的部分
+	if b0 != bl {
+		seps := strings.Count(base[b0:bl], string(Separator))
+		// 一共seps+1个'..'，且它们之间有seps个分隔符
+		size := 2 + seps*3
+		if tl != t0 {
+			size += 1 + tl - t0
+		}
+		buf := make([]byte, size)
+		n := copy(buf, "..")
+		for i := 0; i < seps; i++ {
+			buf[n] = Separator
+			copy(buf[n+1:], "..")
+			n += 3
+		}
+		if t0 != tl {
+			buf[n] = Separator
+			copy(buf[n+1:], targ[t0:])
+		}
+		return string(buf), nil
+	}
+	// base是targ的前缀
+	return targ[t0:], nil
+}
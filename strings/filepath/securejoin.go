@@ -0,0 +1,83 @@
+package filepath
+
+import (
+	"os"
+	"strings"
+)
+
+// SecureJoin 将unsafePath视为root中的路径，解析其中的符号链接以及".."，返回的路径总是在root之中
+//
+// 解析时root被视为"/"：".."不会越过root，绝对路径以及指向绝对路径的符号链接都从root开始解析。
+// 与EvalSymlinks不同，不存在的路径不作为错误，剩余的部分按照字面量拼接，方便调用方之后创建。
+// 与EvalSymlinks相同，最多解析defaultMaxLinks次符号链接，超过时返回ErrTooManyLinks
+//
+// 注意：SecureJoin只保证解析时的安全，解析完成之后root中的符号链接可能被修改(TOCTOU)
+func SecureJoin(root, unsafePath string) (string, error) {
+	root = Clean(root)
+	// 与walkSymlinks相同，通过osFS访问文件系统，Lstat使用可以在单元测试中替换的lstat
+	fsys := osFS{}
+	// 已经解析的路径，相对于root，不以分隔符开头，也不包含".."
+	dest := ""
+	path := unsafePath
+	linksWalked := 0
+
+	for path != "" {
+		// 取出下一个subPath
+		var name string
+		if i := strings.IndexByte(path, Separator); i >= 0 {
+			name, path = path[:i], path[i+1:]
+		} else {
+			name, path = path, ""
+		}
+
+		switch name {
+		case "", ".":
+			// 忽略多余的分隔符以及当前路径'.'
+			continue
+		case "..":
+			// 回退一个子路径，root的".."仍然是root
+			if i := strings.LastIndexByte(dest, Separator); i >= 0 {
+				dest = dest[:i]
+			} else {
+				dest = ""
+			}
+			continue
+		}
+
+		next := name
+		if dest != "" {
+			next = dest + string(Separator) + name
+		}
+		fi, err := fsys.Lstat(Join(root, next))
+		if err != nil {
+			// 不存在的路径按照字面量拼接
+			if os.IsNotExist(err) {
+				dest = next
+				continue
+			}
+			return "", err
+		}
+		// 不是符号链接
+		if fi.Mode()&os.ModeSymlink == 0 {
+			dest = next
+			continue
+		}
+
+		// 符号链接
+		linksWalked++
+		if linksWalked > defaultMaxLinks {
+			return "", ErrTooManyLinks
+		}
+		link, err := fsys.Readlink(Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		// 用符号链接更新剩余的path
+		path = link + string(Separator) + path
+		// link是绝对路径，从root重新开始；相对路径，从符号链接所在的目录开始，dest不变
+		if IsAbs(link) {
+			dest = ""
+		}
+	}
+	return Join(root, dest), nil
+}
@@ -0,0 +1,21 @@
+package filepath
+
+import "strings"
+
+// ToSlash 将path中的分隔符替换为'/'
+// linux下Separator就是'/'，原样返回
+func ToSlash(path string) string {
+	if Separator == '/' {
+		return path
+	}
+	return strings.ReplaceAll(path, string(Separator), "/")
+}
+
+// FromSlash 将path中的'/'替换为分隔符Separator
+// linux下Separator就是'/'，原样返回
+func FromSlash(path string) string {
+	if Separator == '/' {
+		return path
+	}
+	return strings.ReplaceAll(path, "/", string(Separator))
+}
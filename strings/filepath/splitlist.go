@@ -0,0 +1,12 @@
+package filepath
+
+import "strings"
+
+// SplitList 以ListSeparator分割由多个path组成的列表，比如PATH、GOPATH环境变量
+// 与strings.Split不同，空字符串返回空的slice而不是[""]
+func SplitList(path string) []string {
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, string(ListSeparator))
+}
@@ -3,6 +3,7 @@ package filepath
 import (
 	"errors"
 	"os"
+	"strings"
 	"syscall"
 )
 
@@ -11,10 +12,147 @@ var (
 )
 
 func EvalSymlinks(path string) (string, error) {
-	return walkSymlinks(path)
+	return evalSymlinks(path, evalOptions{})
 }
 
-func walkSymlinks(path string) (string, error) {
+// SymlinkFS EvalSymlinksFS所依赖的文件系统操作，语义与os.Lstat、os.Readlink相同
+//
+// 路径以'/'分隔。符号链接的内容是绝对路径时，从SymlinkFS的"/"开始解析。
+// 可以用于解析tar镜像、内存中的文件树、远程快照中的符号链接
+type SymlinkFS interface {
+	Lstat(name string) (os.FileInfo, error)
+	Readlink(name string) (string, error)
+}
+
+// EvalSymlinksFS 与EvalSymlinks相同，但是解析的是fsys中的path
+func EvalSymlinksFS(fsys SymlinkFS, path string) (string, error) {
+	return evalSymlinks(path, evalOptions{fsys: fsys})
+}
+
+// ResolveMode 解析路径时，对不存在的组成部分的处理方式
+type ResolveMode int
+
+const (
+	// ResolveExisting 与realpath -e相同，所有的组成部分都必须存在。EvalSymlinks的行为
+	ResolveExisting ResolveMode = iota
+	// ResolveMissing 与realpath -m相同，组成部分不需要存在，也不需要是目录。
+	// 存在的部分解析符号链接，不存在的部分按照字面量拼接，最终的结果经过Clean
+	ResolveMissing
+)
+
+// EvalSymlinksMode 与EvalSymlinks相同，但是可以通过mode指定如何处理不存在的组成部分
+//
+// 比如/link指向/real时，EvalSymlinksMode("/link/out/../out.txt", ResolveMissing)返回"/real/out.txt"，
+// 即使/real/out以及/real/out.txt都不存在
+func EvalSymlinksMode(path string, mode ResolveMode) (string, error) {
+	return evalSymlinks(path, evalOptions{EvalSymlinksOptions: EvalSymlinksOptions{Mode: mode}})
+}
+
+// SymlinkStep EvalSymlinksTrace中的一次符号链接解析
+type SymlinkStep struct {
+	// Link 符号链接的路径
+	Link string
+	// Target Readlink得到的原始内容
+	Target string
+	// Dest 以Target替换Link之后，继续解析的起点：Target是绝对路径时为"/"，否则为Link所在的目录。
+	// 相对路径的Link位于当前目录时为空
+	Dest string
+}
+
+// SymlinkError EvalSymlinksTrace解析失败时返回的错误，Path为出错的组成部分
+type SymlinkError struct {
+	// Path 出错时正在解析的路径，比如不存在的文件、超过链接次数的符号链接
+	Path string
+	Err  error
+}
+
+func (e *SymlinkError) Error() string {
+	return "EvalSymlinks " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *SymlinkError) Unwrap() error {
+	return e.Err
+}
+
+// EvalSymlinksTrace 与EvalSymlinks相同，同时按顺序返回解析过程中的每一次符号链接
+//
+// 出错时返回*SymlinkError，steps为出错之前已经完成的解析
+func EvalSymlinksTrace(path string) (dest string, steps []SymlinkStep, err error) {
+	dest, err = walkSymlinks(path, evalOptions{steps: &steps})
+	return dest, steps, err
+}
+
+// 默认最多解析的符号链接次数
+const defaultMaxLinks = 255
+
+// EvalSymlinksOptions EvalSymlinksWithOptions的选项，零值与EvalSymlinks的行为相同，但是会检测循环
+type EvalSymlinksOptions struct {
+	// MaxLinks 最多解析的符号链接次数，超过时返回ErrTooManyLinks。<=0时使用255
+	MaxLinks int
+	// Mode 不存在的组成部分的处理方式
+	Mode ResolveMode
+}
+
+// SymlinkCycleError 符号链接构成了循环
+type SymlinkCycleError struct {
+	// Cycle 构成循环的符号链接，按照解析的顺序排列，最后一个与第一个相同
+	Cycle []string
+}
+
+func (e *SymlinkCycleError) Error() string {
+	return "EvalSymlinks: symlink cycle: " + strings.Join(e.Cycle, " -> ")
+}
+
+// EvalSymlinksWithOptions 与EvalSymlinks相同，但是可以通过opts指定最多解析的符号链接次数等
+//
+// 解析时记录每一个符号链接以及其之后剩余的路径，同一个状态第二次出现时说明存在循环，
+// 立即返回*SymlinkCycleError，而不是解析MaxLinks次之后才返回ErrTooManyLinks
+func EvalSymlinksWithOptions(path string, opts EvalSymlinksOptions) (string, error) {
+	return evalSymlinks(path, evalOptions{EvalSymlinksOptions: opts, detectCycles: true})
+}
+
+// evalOptions walkSymlinks的选项
+type evalOptions struct {
+	EvalSymlinksOptions
+	// 为nil时使用操作系统的文件系统
+	fsys SymlinkFS
+	// 是否检测循环
+	detectCycles bool
+	// 不为nil时记录每一次符号链接解析
+	steps *[]SymlinkStep
+}
+
+// evalSymlinks 不记录解析过程的walkSymlinks
+// 返回*SymlinkError中原有的错误，便于os.IsNotExist等判断
+func evalSymlinks(path string, opts evalOptions) (string, error) {
+	dest, err := walkSymlinks(path, opts)
+	if e, ok := err.(*SymlinkError); ok {
+		return "", e.Err
+	}
+	return dest, err
+}
+
+// symlinkState 解析到符号链接link时的状态，rest为link之后剩余的路径
+type symlinkState struct {
+	link, rest string
+}
+
+// walkSymlinks 解析path中的符号链接
+// 出错时返回*SymlinkError
+func walkSymlinks(path string, opts evalOptions) (string, error) {
+	maxLinks := opts.MaxLinks
+	if maxLinks <= 0 {
+		maxLinks = defaultMaxLinks
+	}
+	mode, steps := opts.Mode, opts.steps
+	fsys := opts.fsys
+	if fsys == nil {
+		fsys = osFS{}
+	}
+	// detectCycles时，已经解析过的符号链接，以及其在links中的索引
+	var seen map[symlinkState]int
+	var links []string
+
 	pathSeparator := string(os.PathSeparator)
 	var volLen int
 	// 当path是绝对路径时
@@ -53,6 +191,12 @@ func walkSymlinks(path string) (string, error) {
 				}
 			}
 
+			// 绝对路径回退到根目录，"/.."仍然是"/"。
+			// 不依赖文件系统对"/.."的处理，SymlinkFS的实现不需要理解".."
+			if r < volLen && volLen > 0 {
+				dest = vol
+				continue
+			}
 			// TODO: 不明白这个逻辑？
 			if r < volLen || dest[r+1:] == ".." {
 				// Either path has no slashes
@@ -79,31 +223,48 @@ func walkSymlinks(path string) (string, error) {
 		dest += path[start:end]
 
 		// 符号链接处理
-		fi, err := os.Lstat(dest)
+		fi, err := fsys.Lstat(dest)
 		if err != nil {
-			return "", err
+			// ResolveMissing时，不存在的部分按照字面量拼接，之后的".."同样按照字面量回退
+			if mode == ResolveMissing && (os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR)) {
+				continue
+			}
+			return "", &SymlinkError{Path: dest, Err: err}
 		}
 
 		// 不是符号链接
 		if fi.Mode()&os.ModeSymlink == 0 {
 			// 异常情况处理
-			if !fi.Mode().IsDir() && end < len(path) {
-				return "", syscall.ENOTDIR
+			if !fi.Mode().IsDir() && end < len(path) && mode != ResolveMissing {
+				return "", &SymlinkError{Path: dest, Err: syscall.ENOTDIR}
 			}
 			// fi是目录，或者fi是普通文件且path已经遍历完。正常
 			continue
 		}
 
 		// 符号链接
+		if opts.detectCycles {
+			state := symlinkState{link: dest, rest: path[end:]}
+			if i, ok := seen[state]; ok {
+				cycle := append(links[i:len(links):len(links)], dest)
+				return "", &SymlinkError{Path: dest, Err: &SymlinkCycleError{Cycle: cycle}}
+			}
+			if seen == nil {
+				seen = make(map[symlinkState]int)
+			}
+			seen[state] = len(links)
+			links = append(links, dest)
+		}
 		linksWalked++
-		// 符号链接最大支持255次
-		if linksWalked > 255 {
-			return "", ErrTooManyLinks
+		// 符号链接默认最大支持255次
+		if linksWalked > maxLinks {
+			return "", &SymlinkError{Path: dest, Err: ErrTooManyLinks}
 		}
-		link, err := os.Readlink(dest)
+		link, err := fsys.Readlink(dest)
 		if err != nil {
-			return "", err
+			return "", &SymlinkError{Path: dest, Err: err}
 		}
+		linkPath := dest
 		// 用符号链接更新整个path
 		// path.end对应的是分隔符, path[end:]是剩余的路径
 		path = link + path[end:]
@@ -131,6 +292,9 @@ func walkSymlinks(path string) (string, error) {
 			// 相对路径，从0开始
 			end = 0
 		}
+		if steps != nil {
+			*steps = append(*steps, SymlinkStep{Link: linkPath, Target: link, Dest: dest})
+		}
 	}
 	return Clean(dest), nil
 }
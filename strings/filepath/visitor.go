@@ -0,0 +1,48 @@
+package filepath
+
+import (
+	"context"
+	"os"
+)
+
+// Visitor WalkVisitor遍历时的访问者，分别处理进入目录、文件以及离开目录
+//
+// 返回值的语义与WalkFunc相同，返回SkipDir以外的错误时立即停止遍历，WalkVisitor返回该错误。
+// 返回SkipAll时同样立即停止遍历，但是WalkVisitor返回nil，尚未离开的目录不会再调用LeaveDir
+type Visitor interface {
+	// EnterDir 在读取目录之后、遍历子路径之前调用，读取目录失败时err不为nil。
+	// 返回SkipDir时跳过该目录，并且不会调用LeaveDir
+	//
+	// WalkOptions.Unsorted时，EnterDir在打开目录之后、读取之前调用。读取到一半失败时，
+	// 以该错误对同一个目录第二次调用EnterDir，此时剩余的子路径不再遍历，返回nil或者SkipDir时，LeaveDir仍然只调用一次
+	EnterDir(path string, info os.FileInfo, err error) error
+	// File 非目录的路径，以及Lstat失败的路径(此时info为nil)。
+	// 返回SkipDir时跳过所在目录中剩余的路径，所在目录的LeaveDir仍然会被调用
+	File(path string, info os.FileInfo, err error) error
+	// LeaveDir 目录的所有子路径遍历完成之后调用，只有EnterDir返回nil的目录才会调用。
+	// 目录已经遍历完成，返回SkipDir与返回nil相同
+	LeaveDir(path string, info os.FileInfo) error
+}
+
+// WalkVisitor 遍历root文件树，以先序调用EnterDir、File，以后序调用LeaveDir
+//
+// 适用于需要在目录的子路径全部处理完之后再处理目录的场景，比如统计目录大小、删除已经清空的目录
+func WalkVisitor(root string, opts WalkOptions, v Visitor) error {
+	return newVisitorWalker(osFS{}, opts, v).walkRoot(root)
+}
+
+// newVisitorWalker 返回以v处理各个路径的walker
+func newVisitorWalker(fsys fileSystem, opts WalkOptions, v Visitor) *walker {
+	return &walker{
+		ctx:  context.Background(),
+		fsys: fsys,
+		opts: opts,
+		walkFn: func(path string, info os.FileInfo, err error) error {
+			if info != nil && info.IsDir() {
+				return v.EnterDir(path, info, err)
+			}
+			return v.File(path, info, err)
+		},
+		leaveFn: v.LeaveDir,
+	}
+}
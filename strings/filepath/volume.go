@@ -0,0 +1,7 @@
+package filepath
+
+// VolumeName 返回path开头的卷名
+// 只考虑linux，linux下没有卷名的概念，总是返回""
+func VolumeName(path string) string {
+	return ""
+}
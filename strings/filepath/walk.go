@@ -1,14 +1,21 @@
 package filepath
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io"
+	"io/fs"
 	"os"
-	"sort"
+	"syscall"
 )
 
 // 标识是否跳过本文件所在的目录，不继续扫描本文件所在的目录
 var SkipDir = errors.New("skip this directory")
 
+// SkipAll 标识停止整个遍历。与SkipDir相同，不认为是一个错误，Walk等返回nil
+var SkipAll = errors.New("skip everything and stop the walk")
+
 // 为了单元测试，可以采用monkey.Patch
 var lstat = os.Lstat
 
@@ -18,50 +25,366 @@ type WalkFunc func(path string, info os.FileInfo, err error) error
 
 // Walk 遍历root文件树，并调用walkFn对各个文件进行处理。
 func Walk(root string, walkFn WalkFunc) error {
-	info, err := lstat(root)
+	w := &walker{ctx: context.Background(), fsys: osFS{}, walkFn: walkFn}
+	return w.walkRoot(root)
+}
+
+// WalkContext 与Walk相同，但是可以通过ctx取消遍历
+//
+// 每次读取目录之前检查ctx，ctx被取消时停止遍历并返回ctx.Err()
+func WalkContext(ctx context.Context, root string, walkFn WalkFunc) error {
+	w := &walker{ctx: ctx, fsys: osFS{}, walkFn: walkFn}
+	return w.walkRoot(root)
+}
+
+// WalkFS 与Walk相同，但是遍历的是fsys中的root文件树
+//
+// 遵循io/fs的约定，root及传给walkFn的path都以'/'分隔，且不以'/'开头。遍历整个fsys时root为"."
+// 与Walk相同，不进入指向目录的符号链接：目录中路径的os.FileInfo来自fs.DirEntry.Info()，只有root使用fs.Stat
+func WalkFS(fsys fs.FS, root string, walkFn WalkFunc) error {
+	w := &walker{ctx: context.Background(), fsys: ioFS{fsys: fsys}, walkFn: walkFn}
+	return w.walkRoot(root)
+}
+
+// WalkOptions WalkWithOptions的选项，零值与Walk的行为相同
+type WalkOptions struct {
+	// FollowSymlinks 为true时，进入指向目录的符号链接，传给walkFn的info是链接目标的os.FileInfo。
+	// 以(device, inode)检测循环：符号链接指向正在遍历的祖先目录时，以*SymlinkLoopError调用walkFn，不再进入
+	FollowSymlinks bool
+	// ResolveSymlinks 与FollowSymlinks一起使用。进入符号链接时，以EvalSymlinks解析得到的真实路径代替链接的路径
+	ResolveSymlinks bool
+
+	// 与find(1)的-maxdepth、-mindepth相同，root的深度为0，root中的文件深度为1，以此类推
+	//
+	// MaxDepth > 0时，深度为MaxDepth的目录仍然会调用walkFn，但是不会被读取，更深的路径不会被遍历。
+	// 为了保持零值与Walk的行为相同，MaxDepth为0时不限制深度，除非HasMaxDepth为true
+	MaxDepth int
+	// HasMaxDepth 为true时，MaxDepth为0同样生效：只对root调用walkFn，root是目录时也不会被读取，与-maxdepth 0相同
+	HasMaxDepth bool
+	// MinDepth > 0时，深度小于MinDepth的路径不会调用walkFn，但是仍然会被遍历。
+	// 出错时无论深度都会调用walkFn，保证错误不被忽略
+	MinDepth int
+
+	// Unsorted 为true时不再读取整个目录并排序，而是每次读取BatchSize个目录项，按照读取到的顺序立即遍历。
+	// 无论目录中有多少目录项，占用的内存都是有上限的，代价是遍历的顺序不确定。
+	// 遍历过程中每一层目录都会保持打开
+	Unsorted bool
+	// BatchSize Unsorted时每次读取的目录项数量，<=0时使用defaultBatchSize
+	BatchSize int
+
+	// SameFileSystem 与find(1)的-xdev相同，不进入与root不在同一个文件系统上的目录。
+	// 以syscall.Stat_t中的设备号判断，挂载点本身仍然会调用walkFn，但是不会被读取
+	SameFileSystem bool
+	// ReportMountPoints 与SameFileSystem一起使用。以*MountPointError而不是nil对挂载点调用walkFn
+	ReportMountPoints bool
+
+	// Exclude 匹配任意一个pattern的路径(root除外)不会调用walkFn，目录在读取之前就被跳过。
+	// Include 不为空时，只有匹配任意一个pattern的文件才会调用walkFn，目录不受影响，仍然会被遍历。
+	//
+	// pattern的语法与Compile相同。包含'/'的pattern匹配相对于root的路径，比如"vendor/*/testdata"，
+	// 否则只匹配文件名，比如".git"、"*.tmp"。任意一个pattern存在语法错误时，不会遍历，直接返回ErrBadPattern。
+	// 出错的路径无论是否匹配Include都会调用walkFn，保证错误不被忽略
+	Include []string
+	Exclude []string
+
+	// IgnoreFile 不为空时，比如".gitignore"，进入每个目录时读取其中的该文件，按照gitignore的规则跳过被忽略的路径。
+	// 目录中的规则只作用于该目录，离开目录时移除。与Exclude相同，被忽略的路径不会调用walkFn，被忽略的目录不会被读取
+	IgnoreFile string
+	// Ignore 在所有IgnoreFile之前生效的规则，比如.git/info/exclude，其中的路径相对于root。
+	// 遍历时不会修改Ignore
+	Ignore *IgnoreMatcher
+}
+
+// WalkWithOptions 与Walk相同，但是可以通过opts改变遍历的行为
+func WalkWithOptions(root string, opts WalkOptions, walkFn WalkFunc) error {
+	w := &walker{ctx: context.Background(), fsys: osFS{}, opts: opts, walkFn: walkFn}
+	return w.walkRoot(root)
+}
+
+// SymlinkLoopError FollowSymlinks时，符号链接指向了正在遍历的祖先目录
+type SymlinkLoopError struct {
+	// Path 符号链接的路径
+	Path string
+	// Ancestor 符号链接指向的祖先目录
+	Ancestor string
+}
+
+func (e *SymlinkLoopError) Error() string {
+	return "walk: symlink " + e.Path + " loops back to " + e.Ancestor
+}
+
+// MountPointError SameFileSystem时，目录与root不在同一个文件系统上，没有被遍历
+type MountPointError struct {
+	// Path 被跳过的目录
+	Path string
+}
+
+func (e *MountPointError) Error() string {
+	return "walk: skipping " + e.Path + " on a different file system"
+}
+
+// fileID 唯一标识一个文件
+type fileID struct {
+	dev, ino uint64
+}
+
+// getFileID 只有os.Lstat、os.Stat得到的os.FileInfo才能获取fileID
+func getFileID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// walker 保存一次遍历的ctx、文件系统、选项以及遍历过程中的状态
+type walker struct {
+	ctx    context.Context
+	fsys   fileSystem
+	opts   WalkOptions
+	walkFn WalkFunc
+	// leaveFn 不为nil时，目录的子路径遍历完成之后调用，见Visitor.LeaveDir
+	leaveFn func(path string, info os.FileInfo) error
+
+	// FollowSymlinks时，正在遍历的祖先目录，用于检测循环
+	ancestors map[fileID]string
+	// SameFileSystem时，root所在的设备
+	rootDev    uint64
+	hasRootDev bool
+	// 解析后的Include、Exclude
+	include, exclude []pathFilter
+	// IgnoreFile或者Ignore不为空时，当前生效的gitignore规则
+	ignore *IgnoreMatcher
+}
+
+func (w *walker) walkRoot(root string) error {
+	var err error
+	if w.include, err = compileFilters(w.opts.Include); err != nil {
+		return err
+	}
+	if w.exclude, err = compileFilters(w.opts.Exclude); err != nil {
+		return err
+	}
+	if w.opts.IgnoreFile != "" || w.opts.Ignore != nil {
+		w.ignore = NewIgnoreMatcher()
+		if w.opts.Ignore != nil {
+			w.ignore.rules = append(w.ignore.rules, w.opts.Ignore.rules...)
+		}
+	}
+
+	info, err := w.fsys.Lstat(root)
+	if err == nil && w.opts.FollowSymlinks && info.Mode()&os.ModeSymlink != 0 {
+		root, info, err = w.followSymlink(root, info)
+	}
 	if err != nil {
-		err = walkFn(root, nil, err)
+		err = w.walkFn(root, info, err)
 	} else {
-		err = walk(root, info, walkFn)
+		if w.opts.SameFileSystem {
+			if id, ok := getFileID(info); ok {
+				w.rootDev, w.hasRootDev = id.dev, true
+			}
+		}
+		err = w.walk(root, ".", info, 0)
 	}
-	// SkipDir不认为是一个错误
-	if err == SkipDir {
+	// SkipDir、SkipAll不认为是一个错误
+	if err == SkipDir || err == SkipAll {
 		return nil
 	}
 	return err
 }
 
-// 递归遍历path
+// visit 调用walkFn，深度小于MinDepth且没有出错时跳过
+func (w *walker) visit(path string, info os.FileInfo, err error, depth int) error {
+	if err == nil && depth < w.opts.MinDepth {
+		return nil
+	}
+	return w.walkFn(path, info, err)
+}
+
+// leave 调用leaveFn，深度小于MinDepth时跳过
+// 目录已经遍历完成，返回SkipDir没有意义，与返回nil相同
+func (w *walker) leave(path string, info os.FileInfo, depth int) error {
+	if w.leaveFn == nil || depth < w.opts.MinDepth {
+		return nil
+	}
+	if err := w.leaveFn(path, info); err != SkipDir {
+		return err
+	}
+	return nil
+}
+
+// 递归遍历path, rel为path相对于root的路径，depth为path相对于root的深度
 // 入参包含os.FileInfo的原因是为了减少os.Lstat调用次数
-func walk(path string, info os.FileInfo, walkFn WalkFunc) error {
+//
+// rel不能由Rel(root, path)得到：root以及各级目录可能是符号链接，ResolveSymlinks时path会被替换为解析后的路径
+func (w *walker) walk(path, rel string, info os.FileInfo, depth int) error {
 	if !info.IsDir() {
-		return walkFn(path, info, nil)
+		return w.visit(path, info, nil, depth)
 	}
+	// 已经达到最大深度，不再读取目录
+	if (w.opts.MaxDepth > 0 || w.opts.HasMaxDepth) && depth >= w.opts.MaxDepth {
+		if err := w.visit(path, info, nil, depth); err != nil {
+			return err
+		}
+		return w.leave(path, info, depth)
+	}
+	// 挂载点，不再读取目录
+	if w.isMountPoint(info) {
+		var err error
+		if w.opts.ReportMountPoints {
+			err = &MountPointError{Path: path}
+		}
+		if err := w.visit(path, info, err, depth); err != nil {
+			return err
+		}
+		return w.leave(path, info, depth)
+	}
+
+	// ctx被取消时返回的错误不是SkipDir，会一直向上传递直到Walk返回
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if w.opts.Unsorted {
+		return w.walkUnsorted(path, rel, info, depth)
+	}
+	entries, err := w.fsys.ReadDir(path)
+	if err != nil {
+		if err := w.visit(path, info, err, depth); err != nil {
+			return err
+		}
+		return w.leave(path, info, depth)
+	}
+	if err := w.visit(path, info, nil, depth); err != nil {
+		return err
+	}
+	defer w.enter(path, rel, info)()
+
+	skipErr := w.walkEntries(path, rel, entries, depth)
+	if skipErr != nil && skipErr != SkipDir {
+		return skipErr
+	}
+	// 即使跳过了剩余的子路径，本目录也已经进入，仍然需要leave
+	if err := w.leave(path, info, depth); err != nil {
+		return err
+	}
+	return skipErr
+}
 
-	names, err := readDirNames(path)
+// isMountPoint SameFileSystem时，目录是否与root不在同一个文件系统上
+func (w *walker) isMountPoint(info os.FileInfo) bool {
+	if !w.hasRootDev {
+		return false
+	}
+	id, ok := getFileID(info)
+	return ok && id.dev != w.rootDev
+}
+
+// walkUnsorted Unsorted时遍历目录path，每次读取BatchSize个目录项，读取到之后立即遍历
+//
+// 与walk不同，目录在打开之后、读取之前调用walkFn。打开失败时与walk相同，只调用一次walkFn；
+// 读取到一半失败时，以该错误第二次调用walkFn，不再读取剩余的目录项
+func (w *walker) walkUnsorted(path, rel string, info os.FileInfo, depth int) error {
+	d, err := w.fsys.OpenDir(path)
 	if err != nil {
-		return walkFn(path, info, err)
+		if err := w.visit(path, info, err, depth); err != nil {
+			return err
+		}
+		return w.leave(path, info, depth)
 	}
-	if err := walkFn(path, info, nil); err != nil {
+	defer d.Close()
+	if err := w.visit(path, info, nil, depth); err != nil {
 		return err
 	}
+	defer w.enter(path, rel, info)()
 
-	for _, name := range names {
-		filename := Join(path, name)
-		fileInfo, err := lstat(filename)
+	var skipErr error
+	for skipErr == nil {
+		entries, err := d.ReadDir(batchSize(w.opts.BatchSize))
+		if skipErr = w.walkEntries(path, rel, entries, depth); skipErr != nil && skipErr != SkipDir {
+			return skipErr
+		}
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if err := walkFn(filename, fileInfo, err); err != nil && err != SkipDir {
+			// 返回SkipDir时同样不再读取剩余的目录项
+			if err := w.visit(path, info, err, depth); err != nil && err != SkipDir {
+				return err
+			}
+			break
+		}
+	}
+	if err := w.leave(path, info, depth); err != nil {
+		return err
+	}
+	return skipErr
+}
+
+// enter 进入目录path之前调用，rel为path相对于root的路径，返回的函数在离开目录时调用
+// 1. FollowSymlinks时将目录加入ancestors，离开时移除
+// 2. IgnoreFile不为空时读取目录中的ignore文件，离开时移除其中的规则
+func (w *walker) enter(path, rel string, info os.FileInfo) func() {
+	var id fileID
+	hasID := false
+	if w.opts.FollowSymlinks {
+		if id, hasID = getFileID(info); hasID {
+			if w.ancestors == nil {
+				w.ancestors = make(map[fileID]string)
+			}
+			w.ancestors[id] = path
+		}
+	}
+	nrules := -1
+	if w.ignore != nil && w.opts.IgnoreFile != "" {
+		nrules = len(w.ignore.rules)
+		// 与git相同，无法读取的ignore文件视为不存在
+		if data, err := w.fsys.ReadFile(Join(path, w.opts.IgnoreFile)); err == nil {
+			w.ignore.Add(rel, bytes.NewReader(data))
+		}
+	}
+	return func() {
+		if hasID {
+			delete(w.ancestors, id)
+		}
+		if nrules >= 0 {
+			w.ignore.rules = w.ignore.rules[:nrules]
+		}
+	}
+}
+
+// walkEntries 依次遍历目录path中的entries
+// 文件返回SkipDir时，跳过剩余的子路径并返回SkipDir
+func (w *walker) walkEntries(path, rel string, entries []fs.DirEntry, depth int) error {
+	for _, entry := range entries {
+		name := entry.Name()
+		filename, fileRel := Join(path, name), Join(rel, name)
+		// 在Lstat之前跳过，被排除的目录不会被读取
+		if matchFilters(w.exclude, fileRel, name) {
+			continue
+		}
+		fileInfo, err := w.fsys.EntryInfo(path, entry)
+		// 父目录都没有被忽略，只需要判断filename本身
+		if err == nil && w.ignore != nil && w.ignore.match(fileRel, fileInfo.IsDir()) {
+			continue
+		}
+		if err == nil && w.opts.FollowSymlinks && fileInfo.Mode()&os.ModeSymlink != 0 {
+			filename, fileInfo, err = w.followSymlink(filename, fileInfo)
+		}
+		if err != nil {
+			if err := w.visit(filename, fileInfo, err, depth+1); err != nil && err != SkipDir {
 				return err
 			}
 			// continue
 		} else {
-			if err := walk(filename, fileInfo, walkFn); err != nil {
+			if len(w.include) > 0 && !fileInfo.IsDir() && !matchFilters(w.include, fileRel, name) {
+				continue
+			}
+			if err := w.walk(filename, fileRel, fileInfo, depth+1); err != nil {
 				if err != SkipDir {
 					return err
 				}
 				// if err==SkipDir && filename是文件而非目录的话，则skip本filename所在的dir
 				if !fileInfo.IsDir() {
-					// 此时return的是SkipDir error
 					return err
 				}
 			}
@@ -71,16 +394,36 @@ func walk(path string, info os.FileInfo, walkFn WalkFunc) error {
 	return nil
 }
 
-func readDirNames(dirname string) ([]string, error) {
-	f, err := os.Open(dirname)
-	if err != nil {
-		return nil, err
+// followSymlink 返回符号链接path需要继续遍历的路径以及os.FileInfo
+//
+// 只有指向目录的符号链接才会被替换为链接目标的os.FileInfo，指向文件的以及悬空的符号链接保持原样
+// 指向祖先目录时返回*SymlinkLoopError，此时info仍然是符号链接本身的os.FileInfo
+func (w *walker) followSymlink(path string, linkInfo os.FileInfo) (string, os.FileInfo, error) {
+	info, err := w.fsys.Stat(path)
+	if err != nil || !info.IsDir() {
+		return path, linkInfo, nil
 	}
-	defer f.Close()
-	names, err := f.Readdirnames(-1)
-	if err != nil {
-		return nil, err
+	if id, ok := getFileID(info); ok {
+		if ancestor, ok := w.ancestors[id]; ok {
+			return path, linkInfo, &SymlinkLoopError{Path: path, Ancestor: ancestor}
+		}
+	}
+	if w.opts.ResolveSymlinks {
+		resolved, err := EvalSymlinks(path)
+		if err != nil {
+			return path, linkInfo, err
+		}
+		path = resolved
+	}
+	return path, info, nil
+}
+
+// defaultBatchSize Unsorted时默认每次读取的目录项数量
+const defaultBatchSize = 1024
+
+func batchSize(n int) int {
+	if n <= 0 {
+		return defaultBatchSize
 	}
-	sort.Strings(names)
-	return names, nil
+	return n
 }
@@ -0,0 +1,79 @@
+package filepath
+
+import (
+	"io/fs"
+	"os"
+)
+
+// WalkDirFunc 与WalkFunc作用相同，区别在于遍历到的file or dir以fs.DirEntry的形式给出
+//
+// fs.DirEntry来自于os.ReadDir的结果，已经包含了文件类型信息，只有在调用Info()时才会Lstat
+// 当err != nil时，root的Lstat失败时d为nil；目录的ReadDir失败时d为该目录，且此时是对该目录的第二次调用
+type WalkDirFunc func(path string, d fs.DirEntry, err error) error
+
+// WalkDir 遍历root文件树，并调用fn对各个文件进行处理。
+//
+// 与Walk相比，WalkDir不会对每个子文件调用lstat，在文件数量巨大时可以减少一半的系统调用
+// SkipDir语义与Walk相同：
+// 1. 目录返回SkipDir，跳过该目录
+// 2. 文件返回SkipDir，跳过该文件所在目录中剩余的文件
+// 返回SkipAll时停止整个遍历，WalkDir返回nil
+func WalkDir(root string, fn WalkDirFunc) error {
+	info, err := lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(root, &statDirEntry{info: info}, fn)
+	}
+	// SkipDir、SkipAll不认为是一个错误
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+// 递归遍历path
+// 与walk不同，目录在ReadDir之前就会先调用一次walkDirFn，以便于在读取目录之前就能够跳过
+func walkDir(path string, d fs.DirEntry, walkDirFn WalkDirFunc) error {
+	if err := walkDirFn(path, d, nil); err != nil || !d.IsDir() {
+		// 目录返回SkipDir，只跳过该目录本身
+		if err == SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		// 第二次调用walkDirFn，报告ReadDir的错误
+		if err = walkDirFn(path, d, err); err != nil {
+			if err == SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	// os.ReadDir返回的entries已经按文件名排序
+	for _, entry := range entries {
+		filename := Join(path, entry.Name())
+		if err := walkDir(filename, entry, walkDirFn); err != nil {
+			// 此时SkipDir只可能来自于文件，skip本目录中剩余的文件
+			if err == SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// statDirEntry 将lstat得到的os.FileInfo包装为fs.DirEntry，用于root
+type statDirEntry struct {
+	info os.FileInfo
+}
+
+func (d *statDirEntry) Name() string               { return d.info.Name() }
+func (d *statDirEntry) IsDir() bool                { return d.info.IsDir() }
+func (d *statDirEntry) Type() fs.FileMode          { return d.info.Mode().Type() }
+func (d *statDirEntry) Info() (fs.FileInfo, error) { return d.info, nil }